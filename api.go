@@ -21,7 +21,7 @@ import (
 	"strings"
 )
 
-const APIVERSION = 1.5
+const APIVERSION = 1.6
 const DEFAULTHTTPHOST = "127.0.0.1"
 const DEFAULTHTTPPORT = 4243
 const DEFAULTUNIXSOCKET = "/var/run/docker.sock"
@@ -138,6 +138,30 @@ func postContainersKill(srv *Server, version float64, w http.ResponseWriter, r *
 	return nil
 }
 
+func postContainersPause(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	name := vars["name"]
+	if err := srv.ContainerPause(name); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersUnpause(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	name := vars["name"]
+	if err := srv.ContainerUnpause(name); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func getContainersExport(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -238,6 +262,15 @@ func getEvents(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 	return nil
 }
 
+func getImagesGet(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	name := vars["name"]
+	w.Header().Set("Content-Type", "application/x-tar")
+	return srv.ImageExport(name, w)
+}
+
 func getImagesHistory(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -339,6 +372,20 @@ func postImagesTag(srv *Server, version float64, w http.ResponseWriter, r *http.
 	return nil
 }
 
+func postImagesSquash(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	id, err := srv.ImageSquash(vars["name"], r.Form.Get("parent"), r.Form.Get("repo"), r.Form.Get("tag"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, &APIID{id})
+}
+
 func postCommit(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -425,6 +472,10 @@ func getImagesSearch(srv *Server, version float64, w http.ResponseWriter, r *htt
 	return writeJSON(w, http.StatusOK, outs)
 }
 
+func postImagesLoad(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return srv.ImageLoad(r.Body)
+}
+
 func postImagesInsert(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -498,6 +549,9 @@ func postImagesPush(srv *Server, version float64, w http.ResponseWriter, r *http
 }
 
 func postContainersCreate(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
 	config := &Config{}
 	out := &APIRun{}
 
@@ -515,7 +569,10 @@ func postContainersCreate(srv *Server, version float64, w http.ResponseWriter, r
 		config.Dns = defaultDns
 	}
 
-	id, err := srv.ContainerCreate(config)
+	// ContainerCreate discards the limits the kernel doesn't support
+	cpuset, blkioWeight := config.Cpuset != "", config.BlkioWeight > 0
+
+	id, err := srv.ContainerCreate(config, r.Form.Get("name"))
 	if err != nil {
 		return err
 	}
@@ -529,6 +586,14 @@ func postContainersCreate(srv *Server, version float64, w http.ResponseWriter, r
 		log.Println("WARNING: Your kernel does not support swap limit capabilities. Limitation discarded.")
 		out.Warnings = append(out.Warnings, "Your kernel does not support memory swap capabilities. Limitation discarded.")
 	}
+	if cpuset && !srv.runtime.capabilities.Cpuset {
+		log.Println("WARNING: Your kernel does not support cpuset. Limitation discarded.")
+		out.Warnings = append(out.Warnings, "Your kernel does not support cpuset. Limitation discarded.")
+	}
+	if blkioWeight && !srv.runtime.capabilities.BlkioWeight {
+		log.Println("WARNING: Your kernel does not support block IO weight. Limitation discarded.")
+		out.Warnings = append(out.Warnings, "Your kernel does not support block IO weight. Limitation discarded.")
+	}
 
 	if !config.NetworkDisabled && srv.runtime.capabilities.IPv4ForwardingDisabled {
 		log.Println("Warning: IPv4 forwarding is disabled.")
@@ -557,6 +622,25 @@ func postContainersRestart(srv *Server, version float64, w http.ResponseWriter,
 	return nil
 }
 
+func postContainersRename(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	name := vars["name"]
+	newName := r.Form.Get("name")
+	if newName == "" {
+		return fmt.Errorf("Missing parameter: name")
+	}
+	if err := srv.ContainerRename(name, newName); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func deleteContainers(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -680,6 +764,49 @@ func postContainersResize(srv *Server, version float64, w http.ResponseWriter, r
 	return nil
 }
 
+func getContainersLogs(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	follow, err := getBoolParam(r.Form.Get("follow"))
+	if err != nil {
+		return err
+	}
+	timestamps, err := getBoolParam(r.Form.Get("timestamps"))
+	if err != nil {
+		return err
+	}
+	stdout, err := getBoolParam(r.Form.Get("stdout"))
+	if err != nil {
+		return err
+	}
+	stderr, err := getBoolParam(r.Form.Get("stderr"))
+	if err != nil {
+		return err
+	}
+	tail := -1
+	if value := r.Form.Get("tail"); value != "" && value != "all" {
+		if tail, err = strconv.Atoi(value); err != nil || tail < 0 {
+			return fmt.Errorf("Bad parameter: tail")
+		}
+	}
+	name := vars["name"]
+	if srv.runtime.Get(name) == nil {
+		return fmt.Errorf("No such container: %s", name)
+	}
+
+	// Stop following the logs once the client goes away
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	return srv.ContainerLogs(name, follow, timestamps, tail, stdout, stderr, utils.NewWriteFlusher(w), closed)
+}
+
 func postContainersAttach(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -740,6 +867,103 @@ func postContainersAttach(srv *Server, version float64, w http.ResponseWriter, r
 	return nil
 }
 
+func getContainersStats(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	stream := true
+	if r.Form.Get("stream") != "" {
+		var err error
+		if stream, err = getBoolParam(r.Form.Get("stream")); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return srv.ContainerStats(vars["name"], stream, utils.NewWriteFlusher(w))
+}
+
+func postContainersExec(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	config := &ExecConfig{}
+	if err := json.NewDecoder(r.Body).Decode(config); err != nil {
+		return err
+	}
+	id, err := srv.ContainerExecCreate(vars["name"], config)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, &APIID{id})
+}
+
+func postExecStart(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	id := vars["name"]
+	if _, err := srv.ExecInspect(id); err != nil {
+		return err
+	}
+
+	in, out, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tcpc, ok := in.(*net.TCPConn); ok {
+			tcpc.CloseWrite()
+		} else {
+			in.Close()
+		}
+	}()
+	defer func() {
+		if tcpc, ok := out.(*net.TCPConn); ok {
+			tcpc.CloseWrite()
+		} else if closer, ok := out.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+
+	fmt.Fprintf(out, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+	if err := srv.ExecStart(id, in, out); err != nil {
+		fmt.Fprintf(out, "Error: %s\n", err)
+	}
+	return nil
+}
+
+func postExecResize(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	height, err := strconv.Atoi(r.Form.Get("h"))
+	if err != nil {
+		return err
+	}
+	width, err := strconv.Atoi(r.Form.Get("w"))
+	if err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return srv.ExecResize(vars["name"], height, width)
+}
+
+func getExecByID(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	e, err := srv.ExecInspect(vars["name"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, e)
+}
+
 func wsContainersAttach(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 
 	if err := parseForm(r); err != nil {
@@ -834,6 +1058,9 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 	rawSuppressOutput := r.FormValue("q")
 	rawNoCache := r.FormValue("nocache")
 	rawRm := r.FormValue("rm")
+	rawJSON := r.FormValue("json")
+	rawSquash := r.FormValue("squash")
+	rawNetworkDisabled := r.FormValue("networkdisabled")
 	repoName, tag := utils.ParseRepositoryTag(repoName)
 
 	var context io.Reader
@@ -888,10 +1115,48 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return err
 	}
+	jsonStream, err := getBoolParam(rawJSON)
+	if err != nil {
+		return err
+	}
+	squash, err := getBoolParam(rawSquash)
+	if err != nil {
+		return err
+	}
+	limits := BuildLimits{}
+	if limits.NetworkDisabled, err = getBoolParam(rawNetworkDisabled); err != nil {
+		return err
+	}
+	if rawMemory := r.FormValue("memory"); rawMemory != "" {
+		if limits.Memory, err = strconv.ParseInt(rawMemory, 10, 64); err != nil {
+			return fmt.Errorf("Bad parameter memory: %s", err)
+		}
+		if limits.Memory != 0 && limits.Memory < 524288 {
+			return fmt.Errorf("Memory limit must be given in bytes (minimum 524288 bytes)")
+		}
+	}
+	if rawCpuShares := r.FormValue("cpushares"); rawCpuShares != "" {
+		if limits.CpuShares, err = strconv.ParseInt(rawCpuShares, 10, 64); err != nil {
+			return fmt.Errorf("Bad parameter cpushares: %s", err)
+		}
+	}
+	var buildArgs map[string]string
+	if rawBuildArgs := r.FormValue("buildargs"); rawBuildArgs != "" {
+		if err := json.Unmarshal([]byte(rawBuildArgs), &buildArgs); err != nil {
+			return fmt.Errorf("Invalid build arguments: %s", err)
+		}
+	}
 
-	b := NewBuildFile(srv, utils.NewWriteFlusher(w), !suppressOutput, !noCache, rm)
+	if jsonStream {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	b := NewBuildFile(srv, utils.NewWriteFlusher(w), !suppressOutput, !noCache, rm, squash, jsonStream, buildArgs, limits)
 	id, err := b.Build(context)
 	if err != nil {
+		// The JSON stream already ends with the error event
+		if jsonStream {
+			return nil
+		}
 		fmt.Fprintf(w, "Error build: %s\n", err)
 		return err
 	}
@@ -987,6 +1252,7 @@ func createRouter(srv *Server, logging bool) (*mux.Router, error) {
 			"/images/json":                    getImagesJSON,
 			"/images/viz":                     getImagesViz,
 			"/images/search":                  getImagesSearch,
+			"/images/{name:.*}/get":           getImagesGet,
 			"/images/{name:.*}/history":       getImagesHistory,
 			"/images/{name:.*}/json":          getImagesByName,
 			"/containers/ps":                  getContainersJSON,
@@ -994,16 +1260,21 @@ func createRouter(srv *Server, logging bool) (*mux.Router, error) {
 			"/containers/{name:.*}/export":    getContainersExport,
 			"/containers/{name:.*}/changes":   getContainersChanges,
 			"/containers/{name:.*}/json":      getContainersByName,
+			"/containers/{name:.*}/logs":      getContainersLogs,
 			"/containers/{name:.*}/top":       getContainersTop,
+			"/containers/{name:.*}/stats":     getContainersStats,
 			"/containers/{name:.*}/attach/ws": wsContainersAttach,
+			"/exec/{name:.*}/json":            getExecByID,
 		},
 		"POST": {
 			"/auth":                         postAuth,
 			"/commit":                       postCommit,
 			"/build":                        postBuild,
 			"/images/create":                postImagesCreate,
+			"/images/load":                  postImagesLoad,
 			"/images/{name:.*}/insert":      postImagesInsert,
 			"/images/{name:.*}/push":        postImagesPush,
+			"/images/{name:.*}/squash":      postImagesSquash,
 			"/images/{name:.*}/tag":         postImagesTag,
 			"/containers/create":            postContainersCreate,
 			"/containers/{name:.*}/kill":    postContainersKill,
@@ -1014,6 +1285,12 @@ func createRouter(srv *Server, logging bool) (*mux.Router, error) {
 			"/containers/{name:.*}/resize":  postContainersResize,
 			"/containers/{name:.*}/attach":  postContainersAttach,
 			"/containers/{name:.*}/copy":    postContainersCopy,
+			"/containers/{name:.*}/rename":  postContainersRename,
+			"/containers/{name:.*}/pause":   postContainersPause,
+			"/containers/{name:.*}/unpause": postContainersUnpause,
+			"/containers/{name:.*}/exec":    postContainersExec,
+			"/exec/{name:.*}/start":         postExecStart,
+			"/exec/{name:.*}/resize":        postExecResize,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
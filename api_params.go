@@ -1,12 +1,17 @@
 package docker
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type APIHistory struct {
 	ID        string   `json:"Id"`
 	Tags      []string `json:",omitempty"`
 	Created   int64
 	CreatedBy string `json:",omitempty"`
+	// The layer was merged into the previous image by a squash
+	Squashed bool `json:",omitempty"`
 }
 
 type APIImages struct {
@@ -26,8 +31,11 @@ type APIInfo struct {
 	NGoroutines        int    `json:",omitempty"`
 	MemoryLimit        bool   `json:",omitempty"`
 	SwapLimit          bool   `json:",omitempty"`
+	Cpuset             bool   `json:",omitempty"`
+	BlkioWeight        bool   `json:",omitempty"`
 	IPv4Forwarding     bool   `json:",omitempty"`
 	LXCVersion         string `json:",omitempty"`
+	Driver             string `json:",omitempty"`
 	NEventsListener    int    `json:",omitempty"`
 	KernelVersion      string `json:",omitempty"`
 	IndexServerAddress string `json:",omitempty"`
@@ -45,6 +53,7 @@ type APIRmi struct {
 
 type APIContainers struct {
 	ID         string `json:"Id"`
+	Name       string
 	Image      string
 	Command    string
 	Created    int64
@@ -129,3 +138,62 @@ type APICopy struct {
 	Resource string
 	HostPath string
 }
+
+// APIBuildEvent is an event of the JSON stream of /build. Type is one of:
+//
+//	step:      an instruction starts, with its Step number, Line and Instruction
+//	trigger:   a build trigger of the base image starts, with its Instruction
+//	cache:     the image ID was found in the cache for the current step
+//	nocache:   the current step isn't in the cache
+//	container: the intermediate container ID was created
+//	image:     the current step produced the image ID
+//	output:    Output was written by a command on the Stream stdout or stderr
+//	pull:      Output was written while pulling the base image
+//	remove:    the intermediate container ID was removed
+//	limits:    the intermediate containers are limited to Message
+//	squash:    the layers added by the build were merged into the image ID
+//	warning:   the build succeeds, but Message needs attention
+//	result:    the build succeeded, and produced the image ID
+//	error:     the build failed with Message
+type APIBuildEvent struct {
+	Type        string
+	Step        int    `json:",omitempty"`
+	Line        int    `json:",omitempty"`
+	Instruction string `json:",omitempty"`
+	ID          string `json:"Id,omitempty"`
+	Stream      string `json:",omitempty"`
+	Output      string `json:",omitempty"`
+	Message     string `json:",omitempty"`
+}
+
+// APIExec describes a process started by docker exec. ExitCode is only
+// meaningful once the process isn't Running anymore.
+type APIExec struct {
+	ID        string `json:"Id"`
+	Container string
+	Cmd       []string
+	Tty       bool
+	OpenStdin bool
+	Running   bool
+	ExitCode  int
+}
+
+// APIStats is a sample of the resource usage of a running container.
+// CPUUsage is the CPU time used since the container started, in
+// nanoseconds, and CPUPercent the CPU used since the previous sample, where
+// 100 is one CPU fully used. Memory and block IO are in bytes.
+type APIStats struct {
+	Read             time.Time
+	CPUPercent       float64
+	CPUUsage         uint64
+	MemoryUsage      uint64
+	MemoryMaxUsage   uint64
+	MemoryLimit      uint64
+	MemoryPercent    float64
+	BlkioRead        uint64
+	BlkioWrite       uint64
+	NetworkRxBytes   uint64
+	NetworkRxPackets uint64
+	NetworkTxBytes   uint64
+	NetworkTxPackets uint64
+}
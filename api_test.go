@@ -4,9 +4,11 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"container/list"
 	"encoding/json"
 	"github.com/dotcloud/docker/utils"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -324,7 +326,7 @@ func TestGetContainersJSON(t *testing.T) {
 	container, err := runtime.Create(&Config{
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"echo", "test"},
-	})
+	}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -363,6 +365,7 @@ func TestGetContainersExport(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"touch", "/test"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -413,6 +416,7 @@ func TestGetContainersChanges(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"/bin/rm", "/etc/passwd"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -444,6 +448,90 @@ func TestGetContainersChanges(t *testing.T) {
 	}
 }
 
+func TestGetContainersLogs(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+
+	srv := &Server{runtime: runtime}
+
+	container, err := runtime.Create(
+		&Config{
+			Image: GetTestImage(runtime).ID,
+			Cmd:   []string{"/bin/sh", "-c", "echo one; echo two; echo three"},
+		},
+		"",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(container)
+
+	if err := container.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/containers/"+container.ID+"/logs?stdout=1&tail=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRecorder()
+	if err := getContainersLogs(srv, APIVERSION, r, req, map[string]string{"name": container.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if output := r.Body.String(); output != "two\nthree\n" {
+		t.Fatalf("Expected the last 2 lines, got %q", output)
+	}
+}
+
+func TestGetContainersLogsFollowClosed(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// A running container which doesn't write anything
+	container := &Container{ID: "3f2e8a9c7b1d", root: root}
+	container.State.Running = true
+	log := `{"log":"one\n","stream":"stdout","time":"2014-01-01T00:00:00Z"}` + "\n"
+	if err := ioutil.WriteFile(container.logPath("json"), []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	runtime := &Runtime{containers: list.New(), idIndex: utils.NewTruncIndex()}
+	runtime.containers.PushBack(container)
+	srv := &Server{runtime: runtime}
+
+	done := make(chan error, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done <- getContainersLogs(srv, APIVERSION, w, r, map[string]string{"name": container.ID})
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/containers/" + container.ID + "/logs?stdout=1&follow=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "one\n" {
+		t.Fatalf("Expected %q, got %q", "one\n", line)
+	}
+	resp.Body.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		// Stop the container so that the server can shut down
+		container.State.setStopped(0)
+		t.Fatal("Following the logs didn't stop after the client went away")
+	}
+}
+
 func TestGetContainersTop(t *testing.T) {
 	t.Skip("Fixme. Skipping test for now. Reported error when testing using dind: 'api_test.go:527: Expected 2 processes, found 0.'")
 	runtime, err := newTestRuntime()
@@ -460,6 +548,7 @@ func TestGetContainersTop(t *testing.T) {
 			Cmd:       []string{"/bin/sh", "-c", "cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -541,6 +630,7 @@ func TestGetContainersByName(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"echo", "test"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -573,6 +663,7 @@ func TestPostCommit(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"touch", "/test"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -647,7 +738,11 @@ func TestPostContainersCreate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := os.Stat(path.Join(container.rwPath(), "test")); err != nil {
+	if err := container.EnsureMounted(); err != nil {
+		t.Fatal(err)
+	}
+	defer container.Unmount()
+	if _, err := os.Stat(path.Join(container.RootfsPath(), "test")); err != nil {
 		if os.IsNotExist(err) {
 			utils.Debugf("Err: %s", err)
 			t.Fatalf("The test file has not been created")
@@ -668,6 +763,7 @@ func TestPostContainersKill(t *testing.T) {
 			Cmd:       []string{"/bin/cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -710,6 +806,7 @@ func TestPostContainersRestart(t *testing.T) {
 			Cmd:       []string{"/bin/cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -764,6 +861,7 @@ func TestPostContainersStart(t *testing.T) {
 			Cmd:       []string{"/bin/cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -814,6 +912,7 @@ func TestPostContainersStop(t *testing.T) {
 			Cmd:       []string{"/bin/cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -861,6 +960,7 @@ func TestPostContainersWait(t *testing.T) {
 			Cmd:       []string{"/bin/sleep", "1"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -903,6 +1003,7 @@ func TestPostContainersAttach(t *testing.T) {
 			Cmd:       []string{"/bin/cat"},
 			OpenStdin: true,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -992,7 +1093,7 @@ func TestDeleteContainers(t *testing.T) {
 	container, err := runtime.Create(&Config{
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"touch", "/test"},
-	})
+	}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1018,8 +1119,8 @@ func TestDeleteContainers(t *testing.T) {
 		t.Fatalf("The container as not been deleted")
 	}
 
-	if _, err := os.Stat(path.Join(container.rwPath(), "test")); err == nil {
-		t.Fatalf("The test file has not been deleted")
+	if runtime.driver.Exists(container.ID) {
+		t.Fatalf("The rw layer of the container has not been deleted")
 	}
 }
 
@@ -1151,8 +1252,8 @@ func TestDeleteImages(t *testing.T) {
 			t.Fatalf("The container as not been deleted")
 		}
 
-		if _, err := os.Stat(path.Join(container.rwPath(), "test")); err == nil {
-			t.Fatalf("The test file has not been deleted")
+		if runtime.driver.Exists(container.ID) {
+			t.Fatalf("The rw layer of the container has not been deleted")
 		} */
 }
 
@@ -1182,6 +1283,7 @@ func TestPostContainersCopy(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"touch", "/test.txt"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1,16 +1,22 @@
 package docker
 
 import (
-	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/tar"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type Archive io.Reader
@@ -51,86 +57,641 @@ func DetectCompression(source []byte) Compression {
 	return Uncompressed
 }
 
-func (compression *Compression) Flag() string {
+func (compression *Compression) Extension() string {
 	switch *compression {
+	case Uncompressed:
+		return "tar"
 	case Bzip2:
-		return "j"
+		return "tar.bz2"
 	case Gzip:
-		return "z"
+		return "tar.gz"
 	case Xz:
-		return "J"
+		return "tar.xz"
 	}
 	return ""
 }
 
-func (compression *Compression) Extension() string {
-	switch *compression {
+// IDMap maps a range of Size user or group ids, starting at ContainerID
+// inside of archives, to the range starting at HostID on the host.
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// TarOptions controls how Tar and Untar archive files.
+type TarOptions struct {
+	// Paths to archive, relative to the archived directory. Nil means
+	// the whole directory.
+	Includes []string
+	// Patterns, as understood by filepath.Match, of the relative paths
+	// to leave out of the archive. Excluding a directory excludes its
+	// content. Patterns starting with "!" are exceptions, see matchesExclude.
+	Excludes    []string
+	Compression Compression
+	// Translate the owners of the files between the host and the archive.
+	// Without a mapping, ids are stored and restored unchanged.
+	UIDMaps []IDMap
+	GIDMaps []IDMap
+}
+
+// toHost translates a container id to a host id according to idMaps.
+func toHost(id int, idMaps []IDMap) (int, error) {
+	if len(idMaps) == 0 {
+		return id, nil
+	}
+	for _, m := range idMaps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, nil
+		}
+	}
+	return -1, fmt.Errorf("Container id %d cannot be mapped to a host id", id)
+}
+
+// toContainer translates a host id to a container id according to idMaps.
+func toContainer(id int, idMaps []IDMap) (int, error) {
+	if len(idMaps) == 0 {
+		return id, nil
+	}
+	for _, m := range idMaps {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID, nil
+		}
+	}
+	return -1, fmt.Errorf("Host id %d cannot be mapped to a container id", id)
+}
+
+// CompressStream returns a writer compressing what is written to it with
+// `compression` into `dest`. Closing it flushes the compressed data, but
+// doesn't close `dest`.
+func CompressStream(dest io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
 	case Uncompressed:
-		return "tar"
+		return utils.NopWriteCloser(dest), nil
+	case Gzip:
+		return gzip.NewWriter(dest), nil
 	case Bzip2:
-		return "tar.bz2"
+		// The standard library can only decompress bzip2 and xz
+		return cmdWriter(dest, "bzip2", "-c")
+	case Xz:
+		return cmdWriter(dest, "xz", "-c", "-q")
+	}
+	return nil, fmt.Errorf("Unsupported compression format %s", compression.Extension())
+}
+
+// DecompressStream detects the compression of `archive` and returns a
+// reader of its decompressed content.
+func DecompressStream(archive io.Reader) (io.Reader, error) {
+	buf := bufio.NewReader(archive)
+	header, err := buf.Peek(10)
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("Tarball too short")
+		}
+		return nil, err
+	}
+	compression := DetectCompression(header)
+	utils.Debugf("Archive compression detected: %s", compression.Extension())
+
+	switch compression {
+	case Uncompressed:
+		return buf, nil
 	case Gzip:
-		return "tar.gz"
+		return gzip.NewReader(buf)
+	case Bzip2:
+		return bzip2.NewReader(buf), nil
 	case Xz:
-		return "tar.xz"
+		cmd := exec.Command("xz", "-d", "-c", "-q")
+		cmd.Stdin = buf
+		return CmdStream(cmd)
 	}
-	return ""
+	return nil, fmt.Errorf("Unsupported compression format %s", compression.Extension())
+}
+
+// cmdWriteCloser feeds what is written to it to the stdin of a command.
+type cmdWriteCloser struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func cmdWriter(dest io.Writer, name string, args ...string) (io.WriteCloser, error) {
+	w := &cmdWriteCloser{cmd: exec.Command(name, args...)}
+	w.cmd.Stdout = dest
+	w.cmd.Stderr = &w.stderr
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	w.WriteCloser = stdin
+	if err := w.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close waits for the command to write all its output.
+func (w *cmdWriteCloser) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, w.stderr.Bytes())
+	}
+	return nil
 }
 
 // Tar creates an archive from the directory at `path`, and returns it as a
 // stream of bytes.
 func Tar(path string, compression Compression) (io.Reader, error) {
-	return TarFilter(path, compression, nil)
+	return TarFilter(path, &TarOptions{Compression: compression})
+}
+
+// TarFilter creates an archive from the directory at `srcPath`, only
+// including the files selected by `options`.
+// Files are added in lexical order, and only their content, mode, owner,
+// modification time and extended attributes are recorded: archiving the
+// same files twice gives the same archive.
+func TarFilter(srcPath string, options *TarOptions) (io.Reader, error) {
+	if options == nil {
+		options = &TarOptions{}
+	}
+	pipeR, pipeW := io.Pipe()
+	compressW, err := CompressStream(pipeW, options.Compression)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ta := newTarAppender(compressW, options)
+		err := ta.addTree(srcPath)
+		if err == nil {
+			err = ta.tw.Close()
+		}
+		if err2 := compressW.Close(); err == nil {
+			err = err2
+		}
+		pipeW.CloseWithError(err)
+	}()
+	return pipeR, nil
+}
+
+// filterArchive returns the uncompressed archive without the files whose
+// name skip returns true for.
+func filterArchive(archive Archive, skip func(name string) bool) Archive {
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		tr := tar.NewReader(archive)
+		tw := tar.NewWriter(pipeW)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pipeW.CloseWithError(err)
+				return
+			}
+			if skip(hdr.Name) {
+				continue
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				pipeW.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pipeW.CloseWithError(err)
+				return
+			}
+		}
+		pipeW.CloseWithError(tw.Close())
+	}()
+	return pipeR
+}
+
+// tarAppender writes files to a tar archive, recording hardlinks to files
+// already in the archive as such.
+type tarAppender struct {
+	tw      *tar.Writer
+	options *TarOptions
+	// inode of the files already in the archive -> name in the archive
+	seenFiles map[inodeID]string
+}
+
+func newTarAppender(w io.Writer, options *TarOptions) *tarAppender {
+	return &tarAppender{
+		tw:        tar.NewWriter(w),
+		options:   options,
+		seenFiles: make(map[inodeID]string),
+	}
+}
+
+func (ta *tarAppender) addTree(srcPath string) error {
+	includes := ta.options.Includes
+	if includes == nil {
+		includes = []string{"."}
+	}
+	for _, include := range includes {
+		err := filepath.Walk(filepath.Join(srcPath, include), func(filePath string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(srcPath, filePath)
+			if err != nil {
+				return err
+			}
+			if skip, err := matchesExclude(relPath, ta.options.Excludes); err != nil {
+				return err
+			} else if skip {
+				if !f.IsDir() {
+					return nil
+				}
+				// Look for the files re-included by an exception
+				if hasExceptionUnder(relPath, ta.options.Excludes) {
+					return nil
+				}
+				return filepath.SkipDir
+			}
+			return ta.addTarFile(filePath, relPath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesExclude returns true if `relPath` is excluded by the patterns.
+// A path is excluded by a pattern matching it or one of its parent
+// directories. Patterns starting with "!" are exceptions: they include
+// again the paths excluded by the previous patterns. The last pattern
+// matching a path decides whether it is excluded.
+func matchesExclude(relPath string, patterns []string) (bool, error) {
+	excluded := false
+	for _, pattern := range patterns {
+		exception := strings.HasPrefix(pattern, "!")
+		if exception {
+			pattern = pattern[1:]
+		}
+		if pattern == "" {
+			return false, fmt.Errorf("Invalid exclude pattern: !")
+		}
+		pattern = filepath.Clean(pattern)
+		for pth := relPath; pth != "." && pth != "/"; pth = filepath.Dir(pth) {
+			match, err := filepath.Match(pattern, pth)
+			if err != nil {
+				return false, fmt.Errorf("Invalid exclude pattern %s: %s", pattern, err)
+			}
+			if match {
+				excluded = !exception
+				break
+			}
+		}
+	}
+	return excluded, nil
+}
+
+// hasExceptionUnder returns true if an exception may include a path inside
+// of the directory `relDir`.
+func hasExceptionUnder(relDir string, patterns []string) bool {
+	depth := len(strings.Split(relDir, "/"))
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		parts := strings.Split(filepath.Clean(pattern[1:]), "/")
+		if len(parts) <= depth {
+			continue
+		}
+		if match, _ := filepath.Match(strings.Join(parts[:depth], "/"), relDir); match {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveExcluded deletes the files of the directory at `dir` excluded by
+// the patterns, as understood by TarOptions.Excludes.
+func RemoveExcluded(dir string, patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return filepath.Walk(dir, func(pth string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, pth)
+		if err != nil {
+			return err
+		}
+		if excluded, err := matchesExclude(relPath, patterns); err != nil || !excluded {
+			return err
+		}
+		if f.IsDir() && hasExceptionUnder(relPath, patterns) {
+			return nil
+		}
+		if err := os.RemoveAll(pth); err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
 }
 
-// Tar creates an archive from the directory at `path`, only including files whose relative
-// paths are included in `filter`. If `filter` is nil, then all files are included.
-func TarFilter(path string, compression Compression, filter []string) (io.Reader, error) {
-	args := []string{"tar", "--numeric-owner", "-f", "-", "-C", path}
-	if filter == nil {
-		filter = []string{"."}
+// addTarFile adds the file at `pth` to the archive as `name`.
+func (ta *tarAppender) addTarFile(pth, name string) error {
+	fi, err := os.Lstat(pth)
+	if err != nil {
+		return err
+	}
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(pth); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	// Keep the archive reproducible
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+
+	hdr.Name = filepath.ToSlash(name)
+	if fi.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/"
+	}
+	if hdr.Uid, err = toContainer(hdr.Uid, ta.options.UIDMaps); err != nil {
+		return err
+	}
+	if hdr.Gid, err = toContainer(hdr.Gid, ta.options.GIDMaps); err != nil {
+		return err
+	}
+
+	if stat := getInodeStat(fi); stat != nil {
+		if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+			hdr.Devmajor = devMajor(stat.rdev)
+			hdr.Devminor = devMinor(stat.rdev)
+		}
+		// Files with several names are stored once, the other names
+		// being hardlinks to the first one
+		if hdr.Typeflag == tar.TypeReg && stat.nlink > 1 {
+			if oldName, seen := ta.seenFiles[stat.id]; seen {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = oldName
+				hdr.Size = 0
+			} else {
+				ta.seenFiles[stat.id] = hdr.Name
+			}
+		}
+	}
+
+	if hdr.Xattrs, err = lgetxattrs(pth); err != nil {
+		return err
+	}
+
+	if err := ta.tw.WriteHeader(hdr); err != nil {
+		return err
 	}
-	for _, f := range filter {
-		args = append(args, "-c"+compression.Flag(), f)
+	if hdr.Typeflag == tar.TypeReg {
+		f, err := os.Open(pth)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(ta.tw, f); err != nil {
+			return err
+		}
 	}
-	return CmdStream(exec.Command(args[0], args[1:]...))
+	return nil
 }
 
 // Untar reads a stream of bytes from `archive`, parses it as a tar archive,
-// and unpacks it into the directory at `path`.
+// and unpacks it into the directory at `dest`, which must exist.
 // The archive may be compressed with one of the following algorithms:
 //  identity (uncompressed), gzip, bzip2, xz.
-// FIXME: specify behavior when target path exists vs. doesn't exist.
-func Untar(archive io.Reader, path string) error {
+// Existing files are replaced by the files of the archive with the same
+// name, except directories which are merged. `options` may be nil.
+func Untar(archive io.Reader, dest string, options *TarOptions) error {
 	if archive == nil {
 		return fmt.Errorf("Empty archive")
 	}
+	if options == nil {
+		options = &TarOptions{}
+	}
+	decompressed, err := DecompressStream(archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(decompressed)
 
-	buf := make([]byte, 10)
-	totalN := 0
-	for totalN < 10 {
-		if n, err := archive.Read(buf[totalN:]); err != nil {
-			if err == io.EOF {
-				return fmt.Errorf("Tarball too short")
-			}
+	// The modification time of directories is set once their content is
+	// unpacked
+	var dirs []*tar.Header
+	var dirPaths []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Normalize the name, eg. "./foo/" into "foo"
+		hdr.Name = filepath.Clean(hdr.Name)
+		if hdr.Name == "." && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if !isInDir(filepath.Join(dest, hdr.Name), dest) {
+			return fmt.Errorf("Invalid archive: %s is outside of the destination directory", hdr.Name)
+		}
+		// The archive may have replaced a parent directory by a symlink
+		pth, err := resolveInDir(dest, hdr.Name)
+		if err != nil {
 			return err
+		}
+		if hdr.Uid, err = toHost(hdr.Uid, options.UIDMaps); err != nil {
+			return err
+		}
+		if hdr.Gid, err = toHost(hdr.Gid, options.GIDMaps); err != nil {
+			return err
+		}
+		if err := createTarFile(pth, dest, hdr, tr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+			dirPaths = append(dirPaths, pth)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		hdr := dirs[i]
+		// A later entry may have replaced the directory by a symlink
+		if fi, err := os.Lstat(dirPaths[i]); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err := os.Chtimes(dirPaths[i], hdrAccessTime(hdr), hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInDir returns true if the cleaned path `pth` is `dir` or is inside of it.
+func isInDir(pth, dir string) bool {
+	rel, err := filepath.Rel(dir, pth)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// resolveInDir returns the path of `name` in the directory `dir`. The
+// symlinks found in the parent directories of `name` are resolved as if
+// `dir` was the root directory, so the result is always inside of `dir`.
+// The last element of `name` is not resolved.
+func resolveInDir(dir, name string) (string, error) {
+	resolved := "/"
+	remaining := name
+	links := 0
+	for remaining != "" {
+		var part string
+		if i := strings.Index(remaining, "/"); i >= 0 {
+			part, remaining = remaining[:i], remaining[i+1:]
 		} else {
-			totalN += n
-			utils.Debugf("[tar autodetect] n: %d", n)
+			part, remaining = remaining, ""
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		if remaining == "" {
+			resolved = next
+			break
 		}
+		fi, err := os.Lstat(filepath.Join(dir, next))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("Too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(filepath.Join(dir, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
 	}
-	compression := DetectCompression(buf)
+	return filepath.Join(dir, resolved), nil
+}
 
-	utils.Debugf("Archive compression detected: %s", compression.Extension())
+func hdrAccessTime(hdr *tar.Header) time.Time {
+	if hdr.AccessTime.IsZero() {
+		return hdr.ModTime
+	}
+	return hdr.AccessTime
+}
 
-	cmd := exec.Command("tar", "--numeric-owner", "-f", "-", "-C", path, "-x"+compression.Flag())
-	cmd.Stdin = io.MultiReader(bytes.NewReader(buf), archive)
-	// Hardcode locale environment for predictable outcome regardless of host configuration.
-	//   (see https://github.com/dotcloud/docker/issues/355)
-	cmd.Env = []string{"LANG=en_US.utf-8", "LC_ALL=en_US.utf-8"}
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%s: %s", err, output)
+// createTarFile creates the file described by `hdr` at `pth`, with the
+// content read from `r`. Hardlinks are resolved relatively to `dest`, like
+// the parent directories of `pth` by resolveInDir.
+func createTarFile(pth, dest string, hdr *tar.Header, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+	// Replace any existing file, but merge directories
+	if fi, err := os.Lstat(pth); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(pth); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(pth, os.FileMode(mode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(pth, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if !isInDir(filepath.Join(dest, filepath.Clean(hdr.Linkname)), dest) {
+			return fmt.Errorf("Invalid archive: hardlink %s points outside of the destination directory", hdr.Name)
+		}
+		target, err := resolveInDir(dest, filepath.Clean(hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, pth); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, pth); err != nil {
+			return err
+		}
+	case tar.TypeChar:
+		mode |= syscall.S_IFCHR
+		if err := syscall.Mknod(pth, mode, mkdev(hdr.Devmajor, hdr.Devminor)); err != nil {
+			return err
+		}
+	case tar.TypeBlock:
+		mode |= syscall.S_IFBLK
+		if err := syscall.Mknod(pth, mode, mkdev(hdr.Devmajor, hdr.Devminor)); err != nil {
+			return err
+		}
+	case tar.TypeFifo:
+		if err := syscall.Mkfifo(pth, mode); err != nil {
+			return err
+		}
+	default:
+		utils.Debugf("Skipping %s: unhandled tar header type %d", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	// Only root can give files away, like the tar command
+	if os.Getuid() == 0 {
+		if err := os.Lchown(pth, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.Xattrs {
+		if err := lsetxattr(pth, key, []byte(value)); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeLink || hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// Chmod again, since the mode given at creation is subject to the umask,
+	// and to set the setuid, setgid and sticky bits
+	if err := os.Chmod(pth, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		if err := os.Chtimes(pth, hdrAccessTime(hdr), hdr.ModTime); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -140,11 +701,11 @@ func Untar(archive io.Reader, path string) error {
 // TarUntar aborts and returns the error.
 func TarUntar(src string, filter []string, dst string) error {
 	utils.Debugf("TarUntar(%s %s %s)", src, filter, dst)
-	archive, err := TarFilter(src, Uncompressed, filter)
+	archive, err := TarFilter(src, &TarOptions{Includes: filter})
 	if err != nil {
 		return err
 	}
-	return Untar(archive, dst)
+	return Untar(archive, dst, nil)
 }
 
 // UntarPath is a convenience function which looks for an archive
@@ -152,7 +713,7 @@ func TarUntar(src string, filter []string, dst string) error {
 func UntarPath(src, dst string) error {
 	if archive, err := os.Open(src); err != nil {
 		return err
-	} else if err := Untar(archive, dst); err != nil {
+	} else if err := Untar(archive, dst, nil); err != nil {
 		return err
 	}
 	return nil
@@ -221,7 +782,7 @@ func CopyFileWithTar(src, dst string) error {
 		return err
 	}
 	tw.Close()
-	return Untar(buf, filepath.Dir(dst))
+	return Untar(buf, filepath.Dir(dst), nil)
 }
 
 // CmdStream executes a command, and returns its stdout as a stream.
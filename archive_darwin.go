@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"os"
+	"syscall"
+)
+
+type inodeID struct {
+	dev, ino uint64
+}
+
+type inodeStat struct {
+	id    inodeID
+	nlink uint64
+	rdev  uint64
+}
+
+func getInodeStat(fi os.FileInfo) *inodeStat {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &inodeStat{
+		id:    inodeID{dev: uint64(sys.Dev), ino: sys.Ino},
+		nlink: uint64(sys.Nlink),
+		rdev:  uint64(sys.Rdev),
+	}
+}
+
+func devMajor(dev uint64) int64 {
+	return int64((dev >> 24) & 0xff)
+}
+
+func devMinor(dev uint64) int64 {
+	return int64(dev & 0xffffff)
+}
+
+func mkdev(major, minor int64) int {
+	return int(major<<24 | minor)
+}
+
+// Extended attributes are not archived on darwin
+
+func lgetxattrs(pth string) (map[string]string, error) {
+	return nil, nil
+}
+
+func lsetxattr(pth, name string, value []byte) error {
+	return nil
+}
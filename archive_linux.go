@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"bytes"
+	"github.com/dotcloud/docker/utils"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// inodeID identifies a file on the host, whatever its name
+type inodeID struct {
+	dev, ino uint64
+}
+
+type inodeStat struct {
+	id    inodeID
+	nlink uint64
+	rdev  uint64
+}
+
+func getInodeStat(fi os.FileInfo) *inodeStat {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &inodeStat{
+		id:    inodeID{dev: uint64(sys.Dev), ino: sys.Ino},
+		nlink: uint64(sys.Nlink),
+		rdev:  uint64(sys.Rdev),
+	}
+}
+
+// The device number encoding of glibc's major(), minor() and makedev()
+
+func devMajor(dev uint64) int64 {
+	return int64((dev>>8)&0xfff | (dev>>32)&^0xfff)
+}
+
+func devMinor(dev uint64) int64 {
+	return int64(dev&0xff | (dev>>12)&^0xff)
+}
+
+func mkdev(major, minor int64) int {
+	return int(uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32)
+}
+
+// lgetxattrs returns the extended attributes of the file at `pth`, without
+// following symlinks. It returns nil if the filesystem doesn't support them.
+func lgetxattrs(pth string) (map[string]string, error) {
+	names, err := xattrSyscall(syscall.SYS_LLISTXATTR, pth, "")
+	if err != nil {
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		return nil, &os.PathError{Op: "llistxattr", Path: pth, Err: err}
+	}
+	var xattrs map[string]string
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattrSyscall(syscall.SYS_LGETXATTR, pth, string(name))
+		if err != nil {
+			if err == syscall.ENODATA {
+				// Removed in the meantime
+				continue
+			}
+			return nil, &os.PathError{Op: "lgetxattr", Path: pth, Err: err}
+		}
+		if xattrs == nil {
+			xattrs = make(map[string]string)
+		}
+		xattrs[string(name)] = string(value)
+	}
+	return xattrs, nil
+}
+
+// xattrSyscall calls llistxattr (if name is empty) or lgetxattr, and
+// returns the result in a buffer of the right size.
+func xattrSyscall(trap uintptr, pth, name string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(pth)
+	if err != nil {
+		return nil, err
+	}
+	var n *byte
+	if trap == syscall.SYS_LGETXATTR {
+		if n, err = syscall.BytePtrFromString(name); err != nil {
+			return nil, err
+		}
+	}
+	call := func(buf []byte) (int, error) {
+		var bufp unsafe.Pointer
+		if len(buf) > 0 {
+			bufp = unsafe.Pointer(&buf[0])
+		}
+		var r uintptr
+		var errno syscall.Errno
+		if trap == syscall.SYS_LGETXATTR {
+			r, _, errno = syscall.Syscall6(trap, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(bufp), uintptr(len(buf)), 0, 0)
+		} else {
+			r, _, errno = syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), uintptr(bufp), uintptr(len(buf)))
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(r), nil
+	}
+	for {
+		// Query the size first, then retry if the attribute grew meanwhile
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		size, err = call(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+// lsetxattr sets an extended attribute of the file at `pth`, without
+// following symlinks.
+func lsetxattr(pth, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(pth)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var v unsafe.Pointer
+	if len(value) > 0 {
+		v = unsafe.Pointer(&value[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(v), uintptr(len(value)), 0, 0)
+	if errno == syscall.ENOTSUP {
+		utils.Debugf("Dropping extended attribute %s of %s: not supported by the filesystem", name, pth)
+		return nil
+	}
+	if errno != 0 {
+		return &os.PathError{Op: "lsetxattr", Path: pth, Err: errno}
+	}
+	return nil
+}
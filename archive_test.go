@@ -3,11 +3,14 @@ package docker
 import (
 	"bytes"
 	"fmt"
+	"github.com/dotcloud/tar"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -83,7 +86,7 @@ func tarUntar(t *testing.T, origin string, compression Compression) error {
 		return err
 	}
 	defer os.RemoveAll(tmp)
-	if err := Untar(archive, tmp); err != nil {
+	if err := Untar(archive, tmp, nil); err != nil {
 		return err
 	}
 	if _, err := os.Stat(tmp); err != nil {
@@ -116,3 +119,333 @@ func TestTarUntar(t *testing.T) {
 		}
 	}
 }
+
+func TestTarFilterOptions(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-tarfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	for _, name := range []string{"a/1", "a/2.log", "b/3", "b/c/4", "5.log"} {
+		if err := os.MkdirAll(path.Join(origin, path.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(origin, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive, err := TarFilter(origin, &TarOptions{
+		Includes: []string{"a", "b"},
+		Excludes: []string{"*.log", "a/*.log", "b/c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	expected := []string{"a/", "a/1", "b/", "b/3"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected %v in the archive, found %v", expected, names)
+	}
+}
+
+func TestFilterArchive(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-filterarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	if err := os.MkdirAll(path.Join(origin, "dev"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dev/fuse", "dev/keep"} {
+		if err := ioutil.WriteFile(path.Join(origin, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive, err := Tar(origin, Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := ioutil.TempDir("", "docker-test-filterarchive-dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	filtered := filterArchive(archive, func(name string) bool {
+		return name == "dev/fuse"
+	})
+	if err := Untar(filtered, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path.Join(dest, "dev/fuse")); err == nil {
+		t.Fatal("dev/fuse should have been filtered out of the archive")
+	}
+	if content, err := ioutil.ReadFile(path.Join(dest, "dev/keep")); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "dev/keep" {
+		t.Fatalf("Expected %q in dev/keep, found %q", "dev/keep", content)
+	}
+}
+
+func TestTarUntarHardlinks(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-hardlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	if err := ioutil.WriteFile(path.Join(origin, "1"), []byte("hello world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(path.Join(origin, "1"), path.Join(origin, "2")); err != nil {
+		t.Fatal(err)
+	}
+	dest, err := ioutil.TempDir("", "docker-test-hardlinks-dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	if err := TarUntar(origin, nil, dest); err != nil {
+		t.Fatal(err)
+	}
+	fi1, err := os.Stat(path.Join(dest, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi2, err := os.Stat(path.Join(dest, "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi1, fi2) {
+		t.Fatalf("1 and 2 should be hardlinks of the same file")
+	}
+}
+
+func TestTarReproducible(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	if err := ioutil.WriteFile(path.Join(origin, "1"), []byte("hello world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("1", path.Join(origin, "2")); err != nil {
+		t.Fatal(err)
+	}
+	var archives [][]byte
+	for i := 0; i < 2; i++ {
+		archive, err := Tar(origin, Uncompressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+		// Reading the files changes their access time
+		if _, err := ioutil.ReadFile(path.Join(origin, "1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Fatalf("Archiving the same files twice gave different archives")
+	}
+}
+
+func TestUntarIDMaps(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing the owner of files requires root")
+	}
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0600, Uid: 10, Gid: 20, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	dest, err := ioutil.TempDir("", "docker-test-idmaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	options := &TarOptions{
+		UIDMaps: []IDMap{{ContainerID: 0, HostID: 100000, Size: 1000}},
+		GIDMaps: []IDMap{{ContainerID: 0, HostID: 200000, Size: 1000}},
+	}
+	if err := Untar(buf, dest, options); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(path.Join(dest, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 100010 || st.Gid != 200020 {
+		t.Fatalf("Expected the file to be owned by 100010:200020, not %d:%d", st.Uid, st.Gid)
+	}
+
+	// Archiving the files back gives the original ids
+	if err := os.Chown(dest, 100000, 200000); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := TarFilter(dest, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "file" {
+			if hdr.Uid != 10 || hdr.Gid != 20 {
+				t.Fatalf("Expected the file to be archived with 10:20, not %d:%d", hdr.Uid, hdr.Gid)
+			}
+			break
+		}
+	}
+}
+
+func TestUntarOutsideDest(t *testing.T) {
+	for _, hdr := range []*tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg},
+		{Name: "evil", Linkname: "../../etc/passwd", Typeflag: tar.TypeLink},
+	} {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		dest, err := ioutil.TempDir("", "docker-test-breakout")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+		if err := Untar(buf, dest, nil); err == nil {
+			t.Fatalf("Unpacking %s should have failed", hdr.Name)
+		}
+	}
+}
+
+func TestUntarSymlinkBreakout(t *testing.T) {
+	outside, err := ioutil.TempDir("", "docker-test-breakout-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := ioutil.WriteFile(path.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, hdrs := range [][]*tar.Header{
+		{
+			{Name: "a", Linkname: outside, Typeflag: tar.TypeSymlink},
+			{Name: "a/pwned", Mode: 0600, Typeflag: tar.TypeReg},
+		},
+		{
+			{Name: "a", Linkname: "../../../../../../../../" + outside, Typeflag: tar.TypeSymlink},
+			{Name: "a/pwned", Mode: 0600, Typeflag: tar.TypeReg},
+		},
+		{
+			{Name: "a", Linkname: outside, Typeflag: tar.TypeSymlink},
+			{Name: "pwned", Linkname: "a/secret", Typeflag: tar.TypeLink},
+		},
+	} {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for _, hdr := range hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		dest, err := ioutil.TempDir("", "docker-test-breakout")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+		Untar(buf, dest, nil)
+		if _, err := os.Lstat(path.Join(outside, "pwned")); err == nil {
+			t.Fatalf("Unpacking %s wrote a file outside of the destination directory", hdrs[1].Name)
+		}
+		if fi, err := os.Lstat(path.Join(dest, "pwned")); err == nil {
+			secret, err := os.Lstat(path.Join(outside, "secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(fi, secret) {
+				t.Fatalf("Unpacking %s linked a file outside of the destination directory", hdrs[1].Name)
+			}
+		}
+	}
+}
+
+func TestMatchesExclude(t *testing.T) {
+	patterns := []string{"*.log", "!keep.log", "node_modules", "!node_modules/bar", "docs/*/*.tmp"}
+	for relPath, expected := range map[string]bool{
+		"a.log":                 true,
+		"keep.log":              false,
+		"dir/a.log":             false,
+		"node_modules":          true,
+		"node_modules/foo":      true,
+		"node_modules/foo/x.js": true,
+		"node_modules/bar":      false,
+		"node_modules/bar/x.js": false,
+		"docs/en/a.tmp":         true,
+		"docs/a.tmp":            false,
+		"Dockerfile":            false,
+	} {
+		excluded, err := matchesExclude(relPath, patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded != expected {
+			t.Errorf("%s: expected excluded=%v", relPath, expected)
+		}
+	}
+	if _, err := matchesExclude("a", []string{"["}); err == nil {
+		t.Errorf("Invalid patterns should be rejected")
+	}
+}
+
+func TestRemoveExcluded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-removeexcluded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"Dockerfile", "a.log", "keep.log", "node_modules/foo", "node_modules/bar/x.js"} {
+		if err := os.MkdirAll(path.Join(dir, path.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RemoveExcluded(dir, []string{"*.log", "!keep.log", "node_modules", "!node_modules/bar"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range map[string]bool{
+		"Dockerfile":            true,
+		"a.log":                 false,
+		"keep.log":              true,
+		"node_modules/foo":      false,
+		"node_modules/bar/x.js": true,
+	} {
+		if _, err := os.Stat(path.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s: expected kept=%v (%v)", name, kept, err)
+		}
+	}
+}
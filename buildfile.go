@@ -1,16 +1,20 @@
 package docker
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/dotcloud/docker/parser"
 	"github.com/dotcloud/docker/utils"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
-	"reflect"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -20,17 +24,37 @@ type BuildFile interface {
 	CmdRun(string) error
 }
 
+// BuildLimits restrict the resources of the intermediate containers of a
+// build. They apply to the build only, and are not part of the config of the
+// resulting image.
+type BuildLimits struct {
+	Memory          int64 // Memory limit (in bytes)
+	MemorySwap      int64 // Total memory usage (memory + swap); set `-1' to disable swap
+	CpuShares       int64 // CPU shares (relative weight vs. other containers)
+	NetworkDisabled bool
+}
+
 type buildFile struct {
 	runtime *Runtime
 	srv     *Server
 
 	image        string
+	baseImage    string // The image of the last FROM instruction
 	maintainer   string
 	config       *Config
 	context      string
 	verbose      bool
 	utilizeCache bool
 	rm           bool
+	squash       bool // Merge the layers added on top of baseImage into one
+	// Report the progress as a stream of APIBuildEvent instead of text
+	jsonStream bool
+	limits     BuildLimits
+
+	// Build arguments given by the client, and the arguments declared with
+	// ARG along with their value. They are not part of the image config.
+	buildArgs    map[string]string
+	declaredArgs map[string]*string
 
 	tmpContainers map[string]struct{}
 	tmpImages     map[string]struct{}
@@ -38,11 +62,52 @@ type buildFile struct {
 	out io.Writer
 }
 
+// report writes the progress of the build: the text given by format in the
+// text stream, or event in the JSON stream. Events without a text are only
+// part of the JSON stream, and texts without an event of the text stream.
+func (b *buildFile) report(event *APIBuildEvent, format string, a ...interface{}) {
+	if !b.jsonStream {
+		if format != "" {
+			fmt.Fprintf(b.out, format, a...)
+		}
+		return
+	}
+	if event == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		utils.Debugf("Error marshalling build event: %s", err)
+		return
+	}
+	b.out.Write(append(data, '\n'))
+}
+
+// outputWriter returns the writer to give the output of a command or of a
+// pull. In the JSON stream, each write becomes an event of type typ.
+func (b *buildFile) outputWriter(typ, stream string) io.Writer {
+	if !b.jsonStream {
+		return b.out
+	}
+	return &buildEventWriter{b, typ, stream}
+}
+
+type buildEventWriter struct {
+	b      *buildFile
+	typ    string
+	stream string
+}
+
+func (w *buildEventWriter) Write(p []byte) (int, error) {
+	w.b.report(&APIBuildEvent{Type: w.typ, Stream: w.stream, Output: string(p)}, "")
+	return len(p), nil
+}
+
 func (b *buildFile) clearTmp(containers map[string]struct{}) {
 	for c := range containers {
 		tmp := b.runtime.Get(c)
 		b.runtime.Destroy(tmp)
-		fmt.Fprintf(b.out, "Removing intermediate container %s\n", utils.TruncateID(c))
+		b.report(&APIBuildEvent{Type: "remove", ID: c}, "Removing intermediate container %s\n", utils.TruncateID(c))
 	}
 }
 
@@ -51,7 +116,7 @@ func (b *buildFile) CmdFrom(name string) error {
 	if err != nil {
 		if b.runtime.graph.IsNotExist(err) {
 			remote, tag := utils.ParseRepositoryTag(name)
-			if err := b.srv.ImagePull(remote, tag, b.out, utils.NewStreamFormatter(false), nil, nil, true); err != nil {
+			if err := b.srv.ImagePull(remote, tag, b.outputWriter("pull", ""), utils.NewStreamFormatter(false), nil, nil, true); err != nil {
 				return err
 			}
 			image, err = b.runtime.repositories.LookupImage(name)
@@ -63,13 +128,73 @@ func (b *buildFile) CmdFrom(name string) error {
 		}
 	}
 	b.image = image.ID
-	b.config = &Config{}
+	b.baseImage = image.ID
+	b.config = &Config{
+		Memory:          b.limits.Memory,
+		MemorySwap:      b.limits.MemorySwap,
+		CpuShares:       b.limits.CpuShares,
+		NetworkDisabled: b.limits.NetworkDisabled,
+	}
 	if b.config.Env == nil || len(b.config.Env) == 0 {
 		b.config.Env = append(b.config.Env, "HOME=/", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
 	}
+	// Run the triggers of the base image. Since the config of the
+	// new image starts empty, they are not inherited further.
+	if image.Config != nil && len(image.Config.OnBuild) > 0 {
+		b.report(nil, "# Executing %d build triggers\n", len(image.Config.OnBuild))
+		for _, trigger := range image.Config.OnBuild {
+			b.report(&APIBuildEvent{Type: "trigger", Instruction: trigger}, "Trigger: %s\n", trigger)
+			node, err := parser.ParseInstruction(trigger)
+			if err != nil {
+				return fmt.Errorf("Invalid build trigger %s: %s", trigger, err)
+			}
+			if err := b.dispatch(node); err != nil {
+				return fmt.Errorf("Build trigger %s failed: %s", trigger, err)
+			}
+		}
+	}
 	return nil
 }
 
+// CmdOnbuild records an instruction to run when the image is used as base image.
+func (b *buildFile) CmdOnbuild(trigger string) error {
+	node, err := parser.ParseInstruction("ONBUILD " + trigger)
+	if err != nil {
+		return err
+	}
+	trigger = node.Next.String()
+	b.config.OnBuild = append(b.config.OnBuild, trigger)
+	return b.commit("", b.config.Cmd, fmt.Sprintf("ONBUILD %s", trigger))
+}
+
+// CmdArg declares a build argument, with an optional default value used
+// when the client doesn't give one.
+func (b *buildFile) CmdArg(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	name := parts[0]
+	if value, exists := b.buildArgs[name]; exists {
+		b.declaredArgs[name] = &value
+	} else if len(parts) == 2 {
+		b.declaredArgs[name] = &parts[1]
+	} else {
+		b.declaredArgs[name] = nil
+	}
+	return nil
+}
+
+// argsEnv returns the declared build arguments which have a value, as
+// environment variables. The ones overridden by ENV are left out.
+func (b *buildFile) argsEnv() []string {
+	var env []string
+	for name, value := range b.declaredArgs {
+		if value != nil && b.FindEnvKey(name) < 0 {
+			env = append(env, fmt.Sprintf("%s=%s", name, *value))
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
 func (b *buildFile) CmdMaintainer(name string) error {
 	b.maintainer = name
 	return b.commit("", b.config.Cmd, fmt.Sprintf("MAINTAINER %s", name))
@@ -79,7 +204,12 @@ func (b *buildFile) CmdRun(args string) error {
 	if b.image == "" {
 		return fmt.Errorf("Please provide a source image with `from` prior to run")
 	}
-	config, _, _, err := ParseRun([]string{b.image, "/bin/sh", "-c", args}, nil)
+	var runCmd []string
+	if err := json.Unmarshal([]byte(args), &runCmd); err != nil || len(runCmd) == 0 {
+		utils.Debugf("Error unmarshalling: %v, running with /bin/sh -c", err)
+		runCmd = []string{"/bin/sh", "-c", args}
+	}
+	config, _, _, err := ParseRun(append([]string{b.image}, runCmd...), nil)
 	if err != nil {
 		return err
 	}
@@ -92,20 +222,19 @@ func (b *buildFile) CmdRun(args string) error {
 
 	utils.Debugf("Command to be executed: %v", b.config.Cmd)
 
-	if b.utilizeCache {
-		if cache, err := b.srv.ImageGetCached(b.image, b.config); err != nil {
-			return err
-		} else if cache != nil {
-			fmt.Fprintf(b.out, " ---> Using cache\n")
-			utils.Debugf("[BUILDER] Use cached version")
-			b.image = cache.ID
-			return nil
-		} else {
-			utils.Debugf("[BUILDER] Cache miss")
-		}
+	// The build arguments are set in the environment of the command, and
+	// thus part of the cache lookup, but not in the committed config
+	argsEnv := b.argsEnv()
+	env := b.config.Env
+	b.config.Env = append(argsEnv, env...)
+	hit, err := b.probeCache()
+	if err != nil || hit {
+		b.config.Env = env
+		return err
 	}
-
 	cid, err := b.run()
+	// Creating the container may have added the environment of the image
+	b.config.Env = b.config.Env[len(argsEnv):]
 	if err != nil {
 		return err
 	}
@@ -126,6 +255,8 @@ func (b *buildFile) FindEnvKey(key string) int {
 	return -1
 }
 
+// ReplaceEnvMatches replaces the $VAR and ${VAR} references in value by the
+// variables set with ENV, or else by the build arguments.
 func (b *buildFile) ReplaceEnvMatches(value string) (string, error) {
 	exp, err := regexp.Compile("(\\\\\\\\+|[^\\\\]|\\b|\\A)\\$({?)([[:alnum:]_]+)(}?)")
 	if err != nil {
@@ -136,34 +267,34 @@ func (b *buildFile) ReplaceEnvMatches(value string) (string, error) {
 		match = match[strings.Index(match, "$"):]
 		matchKey := strings.Trim(match, "${}")
 
-		for _, envVar := range b.config.Env {
-			envParts := strings.SplitN(envVar, "=", 2)
-			envKey := envParts[0]
-			envValue := envParts[1]
-
-			if envKey == matchKey {
-				value = strings.Replace(value, match, envValue, -1)
-				break
-			}
+		if k := b.FindEnvKey(matchKey); k >= 0 {
+			envValue := strings.SplitN(b.config.Env[k], "=", 2)[1]
+			value = strings.Replace(value, match, envValue, -1)
+		} else if argValue := b.declaredArgs[matchKey]; argValue != nil {
+			value = strings.Replace(value, match, *argValue, -1)
 		}
 	}
 	return value, nil
 }
 
+// splitFirstWord returns the first word of args, and the rest of args.
+func splitFirstWord(args string) (string, string) {
+	args = strings.TrimSpace(args)
+	i := strings.IndexAny(args, " \t")
+	if i < 0 {
+		return args, ""
+	}
+	return args[:i], strings.TrimSpace(args[i:])
+}
+
 func (b *buildFile) CmdEnv(args string) error {
-	tmp := strings.SplitN(args, " ", 2)
-	if len(tmp) != 2 {
+	key, value := splitFirstWord(args)
+	if value == "" {
 		return fmt.Errorf("Invalid ENV format")
 	}
-	key := strings.Trim(tmp[0], " \t")
-	value := strings.Trim(tmp[1], " \t")
 
 	envKey := b.FindEnvKey(key)
-	replacedValue, err := b.ReplaceEnvMatches(value)
-	if err != nil {
-		return err
-	}
-	replacedVar := fmt.Sprintf("%s=%s", key, replacedValue)
+	replacedVar := fmt.Sprintf("%s=%s", key, value)
 
 	if envKey >= 0 {
 		b.config.Env[envKey] = replacedVar
@@ -197,14 +328,6 @@ func (b *buildFile) CmdUser(args string) error {
 	return b.commit("", b.config.Cmd, fmt.Sprintf("USER %v", args))
 }
 
-func (b *buildFile) CmdInsert(args string) error {
-	return fmt.Errorf("INSERT has been deprecated. Please use ADD instead")
-}
-
-func (b *buildFile) CmdCopy(args string) error {
-	return fmt.Errorf("COPY has been deprecated. Please use ADD instead")
-}
-
 func (b *buildFile) CmdEntrypoint(args string) error {
 	if args == "" {
 		return fmt.Errorf("Entrypoint cannot be empty")
@@ -248,13 +371,27 @@ func (b *buildFile) CmdVolume(args string) error {
 	return nil
 }
 
-func (b *buildFile) addRemote(container *Container, orig, dest string) error {
-	file, err := utils.Download(orig, ioutil.Discard)
+// download fetches the file at url into a temporary file, which the
+// caller must remove.
+func (b *buildFile) download(url string) (string, error) {
+	resp, err := utils.Download(url, ioutil.Discard)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer resp.Body.Close()
+	tmp, err := ioutil.TempFile("", "docker-build-add")
+	if err != nil {
+		return "", err
 	}
-	defer file.Body.Close()
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
 
+func (b *buildFile) addRemote(container *Container, file, orig, dest string) error {
 	// If the destination is a directory, figure out the filename.
 	if strings.HasSuffix(dest, "/") {
 		u, err := url.Parse(orig)
@@ -273,22 +410,35 @@ func (b *buildFile) addRemote(container *Container, orig, dest string) error {
 		dest = dest + filename
 	}
 
-	return container.Inject(file.Body, dest)
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return container.Inject(f, dest)
 }
 
-func (b *buildFile) addContext(container *Container, orig, dest string) error {
+// contextPath returns the path of the file `orig` of the build context.
+func (b *buildFile) contextPath(orig string) (string, error) {
 	origPath := path.Join(b.context, orig)
+	if !strings.HasPrefix(origPath, b.context) {
+		return "", fmt.Errorf("Forbidden path: %s", origPath)
+	}
+	if _, err := os.Stat(origPath); err != nil {
+		return "", fmt.Errorf("%s: no such file or directory", orig)
+	}
+	return origPath, nil
+}
+
+func (b *buildFile) addContext(container *Container, origPath, dest string) error {
 	destPath := path.Join(container.RootfsPath(), dest)
 	// Preserve the trailing '/'
 	if strings.HasSuffix(dest, "/") {
 		destPath = destPath + "/"
 	}
-	if !strings.HasPrefix(origPath, b.context) {
-		return fmt.Errorf("Forbidden path: %s", origPath)
-	}
 	fi, err := os.Stat(origPath)
 	if err != nil {
-		return fmt.Errorf("%s: no such file or directory", orig)
+		return err
 	}
 	if fi.IsDir() {
 		if err := CopyWithTar(origPath, destPath); err != nil {
@@ -308,31 +458,88 @@ func (b *buildFile) addContext(container *Container, orig, dest string) error {
 	return nil
 }
 
+// checksumPath returns a checksum of the file or directory at `pth`. It
+// covers the names, types, modes, symlink targets and contents of the
+// files, but not their owners or modification times, so that two fresh
+// checkouts of the same sources have the same checksum.
+func checksumPath(pth string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(pth, func(filePath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(pth, filePath)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if f.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		size := int64(0)
+		if f.Mode().IsRegular() {
+			size = f.Size()
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00", relPath, f.Mode(), link, size)
+		if f.Mode().IsRegular() {
+			file, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.CopyN(h, file, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func (b *buildFile) CmdAdd(args string) error {
 	if b.context == "" {
 		return fmt.Errorf("No context given. Impossible to use ADD")
 	}
-	tmp := strings.SplitN(args, " ", 2)
-	if len(tmp) != 2 {
+	orig, dest := splitFirstWord(args)
+	if dest == "" {
 		return fmt.Errorf("Invalid ADD format")
 	}
 
-	orig, err := b.ReplaceEnvMatches(strings.Trim(tmp[0], " \t"))
-	if err != nil {
+	// The checksum of the sources is part of the command, so that the
+	// cache is used as long as they don't change
+	var (
+		origPath string
+		err      error
+	)
+	if utils.IsURL(orig) {
+		if origPath, err = b.download(orig); err != nil {
+			return err
+		}
+		defer os.Remove(origPath)
+	} else if origPath, err = b.contextPath(orig); err != nil {
 		return err
 	}
-
-	dest, err := b.ReplaceEnvMatches(strings.Trim(tmp[1], " \t"))
+	sum, err := checksumPath(origPath)
 	if err != nil {
 		return err
 	}
 
 	cmd := b.config.Cmd
-	b.config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) ADD %s in %s", orig, dest)}
+	b.config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) ADD %s (sha256:%s) in %s", orig, sum, dest)}
+	defer func(cmd []string) { b.config.Cmd = cmd }(cmd)
 
 	b.config.Image = b.image
+	if hit, err := b.probeCache(); err != nil || hit {
+		return err
+	}
+
 	// Create the container and start it
-	container, err := b.runtime.Create(b.config)
+	container, err := b.runtime.Create(b.config, "")
 	if err != nil {
 		return err
 	}
@@ -344,20 +551,37 @@ func (b *buildFile) CmdAdd(args string) error {
 	defer container.Unmount()
 
 	if utils.IsURL(orig) {
-		if err := b.addRemote(container, orig, dest); err != nil {
+		if err := b.addRemote(container, origPath, orig, dest); err != nil {
 			return err
 		}
 	} else {
-		if err := b.addContext(container, orig, dest); err != nil {
+		if err := b.addContext(container, origPath, dest); err != nil {
 			return err
 		}
 	}
 
-	if err := b.commit(container.ID, cmd, fmt.Sprintf("ADD %s in %s", orig, dest)); err != nil {
-		return err
+	return b.commit(container.ID, cmd, fmt.Sprintf("ADD %s in %s", orig, dest))
+}
+
+// probeCache looks for an image built from the current image with the
+// current config. If there is one, it becomes the current image.
+func (b *buildFile) probeCache() (bool, error) {
+	if !b.utilizeCache {
+		return false, nil
 	}
-	b.config.Cmd = cmd
-	return nil
+	cache, err := b.srv.ImageGetCached(b.image, b.config)
+	if err != nil {
+		return false, err
+	}
+	if cache == nil {
+		utils.Debugf("[BUILDER] Cache miss")
+		b.report(&APIBuildEvent{Type: "nocache"}, "")
+		return false, nil
+	}
+	b.report(&APIBuildEvent{Type: "cache", ID: cache.ID}, " ---> Using cache\n")
+	utils.Debugf("[BUILDER] Use cached version")
+	b.image = cache.ID
+	return true, nil
 }
 
 func (b *buildFile) run() (string, error) {
@@ -367,12 +591,16 @@ func (b *buildFile) run() (string, error) {
 	b.config.Image = b.image
 
 	// Create the container and start it
-	c, err := b.runtime.Create(b.config)
+	c, err := b.runtime.Create(b.config, "")
 	if err != nil {
 		return "", err
 	}
+	// The config of the container is the cache key of the image committed
+	// from it, so it must not follow the changes of b.config
+	config := *c.Config
+	c.Config = &config
 	b.tmpContainers[c.ID] = struct{}{}
-	fmt.Fprintf(b.out, " ---> Running in %s\n", utils.TruncateID(c.ID))
+	b.report(&APIBuildEvent{Type: "container", ID: c.ID}, " ---> Running in %s\n", utils.TruncateID(c.ID))
 
 	// override the entry point that may have been picked up from the base image
 	c.Path = b.config.Cmd[0]
@@ -385,7 +613,7 @@ func (b *buildFile) run() (string, error) {
 	}
 
 	if b.verbose {
-		err = <-c.Attach(nil, nil, b.out, b.out)
+		err = <-c.Attach(nil, nil, b.outputWriter("output", "stdout"), b.outputWriter("output", "stderr"))
 		if err != nil {
 			return "", err
 		}
@@ -410,25 +638,16 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 		b.config.Cmd = []string{"/bin/sh", "-c", "#(nop) " + comment}
 		defer func(cmd []string) { b.config.Cmd = cmd }(cmd)
 
-		if b.utilizeCache {
-			if cache, err := b.srv.ImageGetCached(b.image, b.config); err != nil {
-				return err
-			} else if cache != nil {
-				fmt.Fprintf(b.out, " ---> Using cache\n")
-				utils.Debugf("[BUILDER] Use cached version")
-				b.image = cache.ID
-				return nil
-			} else {
-				utils.Debugf("[BUILDER] Cache miss")
-			}
+		if hit, err := b.probeCache(); err != nil || hit {
+			return err
 		}
 
-		container, err := b.runtime.Create(b.config)
+		container, err := b.runtime.Create(b.config, "")
 		if err != nil {
 			return err
 		}
 		b.tmpContainers[container.ID] = struct{}{}
-		fmt.Fprintf(b.out, " ---> Running in %s\n", utils.TruncateID(container.ID))
+		b.report(&APIBuildEvent{Type: "container", ID: container.ID}, " ---> Running in %s\n", utils.TruncateID(container.ID))
 		id = container.ID
 		if err := container.EnsureMounted(); err != nil {
 			return err
@@ -444,6 +663,15 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 	// Note: Actually copy the struct
 	autoConfig := *b.config
 	autoConfig.Cmd = autoCmd
+	// The limits of the build don't apply to the containers run from the image
+	if b.limits.Memory != 0 {
+		autoConfig.Memory = 0
+		autoConfig.MemorySwap = 0
+	}
+	if b.limits.CpuShares != 0 {
+		autoConfig.CpuShares = 0
+	}
+	autoConfig.NetworkDisabled = false
 	// Commit the container
 	image, err := b.runtime.Commit(container, "", "", "", b.maintainer, &autoConfig)
 	if err != nil {
@@ -454,63 +682,180 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 	return nil
 }
 
-// Long lines can be split with a backslash
-var lineContinuation = regexp.MustCompile(`\s*\\\s*\n`)
+// ReadDockerIgnore returns the exclude patterns listed in the .dockerignore
+// file of a build context, one per line. Blank lines and lines starting
+// with # are ignored. The Dockerfile and the .dockerignore file itself are
+// never excluded.
+func ReadDockerIgnore(contextDir string) ([]string, error) {
+	data, err := ioutil.ReadFile(path.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var excludes []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := matchesExclude("", []string{line}); err != nil {
+			return nil, fmt.Errorf("Invalid .dockerignore: %s", err)
+		}
+		excludes = append(excludes, line)
+	}
+	if excludes != nil {
+		excludes = append(excludes, "!Dockerfile", "!.dockerignore")
+	}
+	return excludes, nil
+}
+
+// The functions implementing the instructions listed in parser.Instructions
+var instructionHandlers map[string]func(*buildFile, string) error
+
+func init() {
+	// Not initialized statically, since CmdFrom refers to it
+	instructionHandlers = map[string]func(*buildFile, string) error{
+		"from":       (*buildFile).CmdFrom,
+		"maintainer": (*buildFile).CmdMaintainer,
+		"run":        (*buildFile).CmdRun,
+		"cmd":        (*buildFile).CmdCmd,
+		"expose":     (*buildFile).CmdExpose,
+		"env":        (*buildFile).CmdEnv,
+		"add":        (*buildFile).CmdAdd,
+		"entrypoint": (*buildFile).CmdEntrypoint,
+		"volume":     (*buildFile).CmdVolume,
+		"user":       (*buildFile).CmdUser,
+		"workdir":    (*buildFile).CmdWorkdir,
+		"onbuild":    (*buildFile).CmdOnbuild,
+		"arg":        (*buildFile).CmdArg,
+	}
+}
+
+// Instructions whose arguments are not expanded by the builder: the shell
+// expands them for RUN, CMD and ENTRYPOINT, and triggers are expanded when
+// they run
+var unexpandedInstructions = map[string]bool{
+	"run":        true,
+	"cmd":        true,
+	"entrypoint": true,
+	"onbuild":    true,
+}
+
+func (b *buildFile) dispatch(node *parser.Node) error {
+	handler, exists := instructionHandlers[node.Instruction]
+	if !exists {
+		return fmt.Errorf("Unknown instruction: %s", strings.ToUpper(node.Instruction))
+	}
+	args := node.Raw
+	if !unexpandedInstructions[node.Instruction] {
+		var err error
+		if args, err = b.ReplaceEnvMatches(args); err != nil {
+			return err
+		}
+	}
+	return handler(b, args)
+}
 
-func (b *buildFile) Build(context io.Reader) (string, error) {
+// checkLimits discards the limits which are not supported by the kernel, and
+// reports the ones applied to the intermediate containers.
+func (b *buildFile) checkLimits() {
+	if b.limits.Memory > 0 && !b.runtime.capabilities.MemoryLimit {
+		msg := "Your kernel does not support memory limit capabilities. Limitation discarded."
+		b.report(&APIBuildEvent{Type: "warning", Message: msg}, "[Warning] %s\n", msg)
+		b.limits.Memory = 0
+	}
+	if b.limits.Memory > 0 && !b.runtime.capabilities.SwapLimit {
+		b.limits.MemorySwap = -1
+	}
+	var limits []string
+	if b.limits.Memory > 0 {
+		limits = append(limits, fmt.Sprintf("memory=%d", b.limits.Memory))
+	}
+	if b.limits.CpuShares > 0 {
+		limits = append(limits, fmt.Sprintf("cpu-shares=%d", b.limits.CpuShares))
+	}
+	if b.limits.NetworkDisabled {
+		limits = append(limits, "network=disabled")
+	}
+	if len(limits) > 0 {
+		msg := strings.Join(limits, " ")
+		b.report(&APIBuildEvent{Type: "limits", Message: msg}, "Build containers limited to: %s\n", msg)
+	}
+}
+
+func (b *buildFile) Build(context io.Reader) (id string, err error) {
+	defer func() {
+		if err != nil {
+			b.report(&APIBuildEvent{Type: "error", Message: err.Error()}, "")
+		}
+	}()
 	// FIXME: @creack any reason for using /tmp instead of ""?
 	// FIXME: @creack "name" is a terrible variable name
 	name, err := ioutil.TempDir("/tmp", "docker-build")
 	if err != nil {
 		return "", err
 	}
-	if err := Untar(context, name); err != nil {
+	if err := Untar(context, name, nil); err != nil {
 		return "", err
 	}
 	defer os.RemoveAll(name)
+	// The client already honors .dockerignore, but remote contexts don't
+	excludes, err := ReadDockerIgnore(name)
+	if err != nil {
+		return "", err
+	}
+	if err := RemoveExcluded(name, excludes); err != nil {
+		return "", err
+	}
 	b.context = name
-	filename := path.Join(name, "Dockerfile")
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	b.checkLimits()
+	f, err := os.Open(path.Join(name, "Dockerfile"))
+	if os.IsNotExist(err) {
 		return "", fmt.Errorf("Can't build a directory with no Dockerfile")
+	} else if err != nil {
+		return "", err
 	}
-	fileBytes, err := ioutil.ReadFile(filename)
+	defer f.Close()
+	dockerfile, err := parser.Parse(f)
 	if err != nil {
 		return "", err
 	}
-	dockerfile := string(fileBytes)
-	dockerfile = lineContinuation.ReplaceAllString(dockerfile, "")
-	stepN := 0
-	for _, line := range strings.Split(dockerfile, "\n") {
-		line = strings.Trim(strings.Replace(line, "\t", " ", -1), " \t\r\n")
-		// Skip comments and empty line
-		if len(line) == 0 || line[0] == '#' {
-			continue
+	for i, node := range dockerfile.Nodes {
+		b.report(&APIBuildEvent{Type: "step", Step: i + 1, Line: node.Line, Instruction: node.String()}, "Step %d : %s\n", i+1, node)
+		if err := b.dispatch(node); err != nil {
+			return "", err
 		}
-		tmp := strings.SplitN(line, " ", 2)
-		if len(tmp) != 2 {
-			return "", fmt.Errorf("Invalid Dockerfile format")
+		if b.image != "" {
+			b.report(&APIBuildEvent{Type: "image", ID: b.image}, " ---> %v\n", utils.TruncateID(b.image))
 		}
-		instruction := strings.ToLower(strings.Trim(tmp[0], " "))
-		arguments := strings.Trim(tmp[1], " ")
-
-		method, exists := reflect.TypeOf(b).MethodByName("Cmd" + strings.ToUpper(instruction[:1]) + strings.ToLower(instruction[1:]))
-		if !exists {
-			fmt.Fprintf(b.out, "# Skipping unknown instruction %s\n", strings.ToUpper(instruction))
-			continue
+	}
+	var unused []string
+	for name := range b.buildArgs {
+		if _, exists := b.declaredArgs[name]; !exists {
+			unused = append(unused, name)
 		}
-
-		stepN += 1
-		fmt.Fprintf(b.out, "Step %d : %s %s\n", stepN, strings.ToUpper(instruction), arguments)
-
-		ret := method.Func.Call([]reflect.Value{reflect.ValueOf(b), reflect.ValueOf(arguments)})[0].Interface()
-		if ret != nil {
-			return "", ret.(error)
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		msg := fmt.Sprintf("One or more build-args %v were not consumed", unused)
+		b.report(&APIBuildEvent{Type: "warning", Message: msg}, "[Warning] %s\n", msg)
+	}
+	if b.squash && b.image != "" && b.image != b.baseImage {
+		img, err := b.runtime.graph.Get(b.image)
+		if err != nil {
+			return "", err
 		}
-
-		fmt.Fprintf(b.out, " ---> %v\n", utils.TruncateID(b.image))
+		squashed, err := b.runtime.graph.Squash(img, b.baseImage)
+		if err != nil {
+			return "", err
+		}
+		b.report(&APIBuildEvent{Type: "squash", ID: squashed.ID}, "Squashed %d layers into %s\n", len(squashed.Squashed), squashed.ShortID())
+		b.image = squashed.ID
 	}
 	if b.image != "" {
-		fmt.Fprintf(b.out, "Successfully built %s\n", utils.TruncateID(b.image))
+		b.report(&APIBuildEvent{Type: "result", ID: b.image}, "Successfully built %s\n", utils.TruncateID(b.image))
 		if b.rm {
 			b.clearTmp(b.tmpContainers)
 		}
@@ -519,7 +864,7 @@ func (b *buildFile) Build(context io.Reader) (string, error) {
 	return "", fmt.Errorf("An error occurred during the build\n")
 }
 
-func NewBuildFile(srv *Server, out io.Writer, verbose, utilizeCache, rm bool) BuildFile {
+func NewBuildFile(srv *Server, out io.Writer, verbose, utilizeCache, rm, squash, jsonStream bool, buildArgs map[string]string, limits BuildLimits) BuildFile {
 	return &buildFile{
 		runtime:       srv.runtime,
 		srv:           srv,
@@ -530,5 +875,10 @@ func NewBuildFile(srv *Server, out io.Writer, verbose, utilizeCache, rm bool) Bu
 		verbose:       verbose,
 		utilizeCache:  utilizeCache,
 		rm:            rm,
+		squash:        squash,
+		jsonStream:    jsonStream,
+		limits:        limits,
+		buildArgs:     buildArgs,
+		declaredArgs:  make(map[string]*string),
 	}
 }
@@ -1,13 +1,21 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/dotcloud/docker/parser"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // mkTestContext generates a build context from the contents of the provided dockerfile.
@@ -189,6 +197,27 @@ run    [ "$(cat /bar/withfile)" = "test2" ]
 		},
 		nil,
 	},
+
+	// Files excluded by .dockerignore can't be added
+	{
+		`
+from {IMAGE}
+add    . /ctx
+run    [ "$(cat /ctx/keep.log)" = "kept" ]
+run    [ ! -e /ctx/ignored.log ]
+run    [ ! -e /ctx/node_modules/foo ]
+run    [ "$(cat /ctx/node_modules/bar)" = "bar" ]
+run    [ -e /ctx/.dockerignore ]
+`,
+		[][2]string{
+			{".dockerignore", "# logs\n*.log\n!keep.log\nnode_modules\n!node_modules/bar\n"},
+			{"keep.log", "kept"},
+			{"ignored.log", "ignored"},
+			{"node_modules/foo", "foo"},
+			{"node_modules/bar", "bar"},
+		},
+		nil,
+	},
 }
 
 // FIXME: test building with 2 successive overlapping ADD commands
@@ -257,7 +286,7 @@ func buildImage(context testContextTemplate, t *testing.T, srv *Server, useCache
 	ip := srv.runtime.networkManager.bridgeNetwork.IP
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := NewBuildFile(srv, ioutil.Discard, false, useCache, false)
+	buildfile := NewBuildFile(srv, ioutil.Discard, false, useCache, false, false, false, nil, BuildLimits{})
 	id, err := buildfile.Build(mkTestContext(dockerfile, context.files, t))
 	if err != nil {
 		t.Fatal(err)
@@ -432,6 +461,361 @@ func TestBuildImageWithCache(t *testing.T) {
 	}
 }
 
+func TestBuildADDWithCache(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	template := testContextTemplate{`
+        from {IMAGE}
+        add foo /foo
+        add http://{SERVERADDR}/bar /bar
+        run [ "$(cat /foo)" = "hello" ]
+        `,
+		[][2]string{{"foo", "hello"}}, [][2]string{{"/bar", "world"}}}
+
+	img := buildImage(template, t, srv, true)
+	imageId := img.ID
+
+	// Unchanged sources use the cache
+	img = buildImage(template, t, srv, true)
+	if imageId != img.ID {
+		t.Fatalf("Image ids should match: %s != %s", imageId, img.ID)
+	}
+
+	// So do changes to the files which are not added
+	template.files = [][2]string{{"foo", "hello"}, {"other", "unused"}}
+	img = buildImage(template, t, srv, true)
+	if imageId != img.ID {
+		t.Fatalf("Image ids should match: %s != %s", imageId, img.ID)
+	}
+	// Changed sources invalidate it
+	template.remoteFiles = [][2]string{{"/bar", "world!"}}
+	img = buildImage(template, t, srv, true)
+	if imageId == img.ID {
+		t.Fatalf("Changing a remote file should invalidate the cache")
+	}
+}
+
+func TestBuildOnBuildTrigger(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	parent := buildImage(testContextTemplate{`
+        from {IMAGE}
+        onbuild add foo /foo
+        onbuild run echo world > /bar
+        `,
+		nil, nil}, t, srv, true)
+	expected := []string{"ADD foo /foo", "RUN echo world > /bar"}
+	if !reflect.DeepEqual(parent.Config.OnBuild, expected) {
+		t.Fatalf("Expected the triggers %v, found %v", expected, parent.Config.OnBuild)
+	}
+
+	child := buildImage(testContextTemplate{`
+        from ` + parent.ID + `
+        run [ "$(cat /foo)" = "hello" ]
+        run [ "$(cat /bar)" = "world" ]
+        `,
+		[][2]string{{"foo", "hello"}}, nil}, t, srv, true)
+	if len(child.Config.OnBuild) != 0 {
+		t.Fatalf("Triggers should not be inherited, found %v", child.Config.OnBuild)
+	}
+}
+
+func TestOnBuildInvalidTriggers(t *testing.T) {
+	b := &buildFile{config: &Config{}}
+	for _, trigger := range []string{"ONBUILD RUN true", "FROM busybox", "MAINTAINER me", "UNKNOWN foo", "RUN"} {
+		if err := b.CmdOnbuild(trigger); err == nil {
+			t.Errorf("ONBUILD %s should be refused", trigger)
+		}
+	}
+	if len(b.config.OnBuild) != 0 {
+		t.Fatalf("Invalid triggers should not be recorded")
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	b := &buildFile{
+		config:       &Config{Env: []string{"HOME=/"}},
+		buildArgs:    map[string]string{"VERSION": "1.2", "PROXY": "http://proxy"},
+		declaredArgs: make(map[string]*string),
+	}
+	for _, arg := range []string{"VERSION=1.0", "FLAVOR=slim", "HOME", "UNSET"} {
+		if err := b.CmdArg(arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, err := b.ReplaceEnvMatches("app-$VERSION-${FLAVOR} $HOME $UNSET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "app-1.2-slim / $UNSET"; value != expected {
+		t.Errorf("Expected %q, found %q", expected, value)
+	}
+	// PROXY isn't declared, and HOME is set with ENV
+	if env := b.argsEnv(); !reflect.DeepEqual(env, []string{"FLAVOR=slim", "VERSION=1.2"}) {
+		t.Errorf("Unexpected environment: %v", env)
+	}
+}
+
+// buildWithOptions builds dockerfile without context, with the given build
+// arguments and limits.
+func buildWithOptions(srv *Server, dockerfile string, buildArgs map[string]string, limits BuildLimits, t *testing.T) *Image {
+	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false, false, false, buildArgs, limits)
+	id, err := buildfile.Build(mkTestContext(constructDockerfile(dockerfile, nil, ""), nil, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := srv.ImageInspect(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestBuildArgsNotCommitted(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	dockerfile := `
+        from {IMAGE}
+        arg VERSION=1.0
+        run [ "$VERSION" = 2.0 ]
+        workdir /opt/$VERSION
+        `
+	img := buildWithOptions(srv, dockerfile, map[string]string{"VERSION": "2.0"}, BuildLimits{}, t)
+	if img.Config.WorkingDir != "/opt/2.0" {
+		t.Errorf("Expected the build argument to be substituted, found %s", img.Config.WorkingDir)
+	}
+	for _, env := range img.Config.Env {
+		if strings.HasPrefix(env, "VERSION=") {
+			t.Errorf("Build arguments should not be committed: %v", img.Config.Env)
+		}
+	}
+}
+
+func TestBuildArgsWithCache(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	dockerfile := `
+        from {IMAGE}
+        arg VERSION
+        run echo $VERSION > /version
+        `
+	img := buildWithOptions(srv, dockerfile, map[string]string{"VERSION": "1.0"}, BuildLimits{}, t)
+	imageId := img.ID
+
+	img = buildWithOptions(srv, dockerfile, map[string]string{"VERSION": "1.0"}, BuildLimits{}, t)
+	if imageId != img.ID {
+		t.Fatalf("Image ids should match: %s != %s", imageId, img.ID)
+	}
+	img = buildWithOptions(srv, dockerfile, map[string]string{"VERSION": "2.0"}, BuildLimits{}, t)
+	if imageId == img.ID {
+		t.Fatalf("Changing a build argument should invalidate the cache")
+	}
+}
+
+func TestBuildLimits(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	img := buildWithOptions(srv, `
+        from {IMAGE}
+        run touch /foo
+        `, nil, BuildLimits{CpuShares: 512, NetworkDisabled: true}, t)
+	if img.ContainerConfig.CpuShares != 512 || !img.ContainerConfig.NetworkDisabled {
+		t.Errorf("The intermediate containers should be limited: %#v", img.ContainerConfig)
+	}
+	if img.Config.CpuShares != 0 || img.Config.NetworkDisabled {
+		t.Errorf("The limits should not be committed: %#v", img.Config)
+	}
+}
+
+func TestBuildSquash(t *testing.T) {
+	runtime, err := newTestRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nuke(runtime)
+
+	srv := &Server{
+		runtime:     runtime,
+		pullingPool: make(map[string]struct{}),
+		pushingPool: make(map[string]struct{}),
+	}
+
+	dockerfile := constructDockerfile(`
+        from {IMAGE}
+        run touch /a
+        run rm /a && touch /b
+        cmd ["cat", "/b"]
+        `, nil, "")
+	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false, true, false, nil, BuildLimits{})
+	id, err := buildfile.Build(mkTestContext(dockerfile, nil, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := srv.runtime.graph.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Parent != unitTestImageID || len(img.Squashed) != 3 {
+		t.Fatalf("Expected the 3 layers of the build to be squashed, found %#v", img)
+	}
+	if len(img.Config.Cmd) != 2 || img.Config.Cmd[0] != "cat" {
+		t.Fatalf("The config of the build should be kept: %#v", img.Config)
+	}
+	changes, err := srv.runtime.graph.driver.Changes(img.ID, img.Parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range changes {
+		if change.Path == "/a" {
+			t.Fatalf("Files deleted by the build should not be in the squashed layer")
+		}
+	}
+}
+
+func TestBuildJSONStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b := &buildFile{out: buf, jsonStream: true}
+	b.report(&APIBuildEvent{Type: "step", Step: 1, Line: 2, Instruction: "FROM busybox"}, "Step %d : %s\n", 1, "FROM busybox")
+	b.report(nil, "# Only in the text stream\n")
+	fmt.Fprint(b.outputWriter("output", "stderr"), "hello\n")
+	b.report(&APIBuildEvent{Type: "nocache"}, "")
+
+	expected := []APIBuildEvent{
+		{Type: "step", Step: 1, Line: 2, Instruction: "FROM busybox"},
+		{Type: "output", Stream: "stderr", Output: "hello\n"},
+		{Type: "nocache"},
+	}
+	dec := json.NewDecoder(buf)
+	for _, e := range expected {
+		var event APIBuildEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event != e {
+			t.Errorf("Expected %#v, found %#v", e, event)
+		}
+	}
+	if err := dec.Decode(&APIBuildEvent{}); err != io.EOF {
+		t.Fatalf("Expected the end of the stream, found %v", err)
+	}
+
+	b = &buildFile{out: buf}
+	b.report(&APIBuildEvent{Type: "step", Step: 1}, "Step %d : %s\n", 1, "FROM busybox")
+	b.report(&APIBuildEvent{Type: "nocache"}, "")
+	if buf.String() != "Step 1 : FROM busybox\n" {
+		t.Fatalf("Unexpected text stream: %q", buf.String())
+	}
+}
+
+func TestInstructionHandlers(t *testing.T) {
+	for _, instruction := range parser.Instructions {
+		if _, exists := instructionHandlers[instruction]; !exists {
+			t.Errorf("No handler for %s", instruction)
+		}
+	}
+	if len(instructionHandlers) != len(parser.Instructions) {
+		t.Errorf("Some handlers are not listed in parser.Instructions")
+	}
+}
+
+func TestChecksumPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(path.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "d", "f"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := checksumPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modification times don't matter
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path.Join(dir, "d", "f"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if sum2, err := checksumPath(dir); err != nil {
+		t.Fatal(err)
+	} else if sum2 != sum {
+		t.Fatalf("Touching a file shouldn't change the checksum")
+	}
+
+	for _, change := range []func() error{
+		func() error { return ioutil.WriteFile(path.Join(dir, "d", "f"), []byte("world"), 0644) },
+		func() error { return os.Chmod(path.Join(dir, "d", "f"), 0755) },
+		func() error { return os.Rename(path.Join(dir, "d", "f"), path.Join(dir, "d", "g")) },
+		func() error { return os.Symlink("g", path.Join(dir, "d", "h")) },
+	} {
+		if err := change(); err != nil {
+			t.Fatal(err)
+		}
+		newSum, err := checksumPath(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newSum == sum {
+			t.Fatalf("The checksum should have changed")
+		}
+		sum = newSum
+	}
+}
+
 func TestBuildImageWithoutCache(t *testing.T) {
 	runtime, err := newTestRuntime()
 	if err != nil {
@@ -498,7 +882,7 @@ func TestForbiddenContextPath(t *testing.T) {
 	ip := srv.runtime.networkManager.bridgeNetwork.IP
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false)
+	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false, false, false, nil, BuildLimits{})
 	_, err = buildfile.Build(mkTestContext(dockerfile, context.files, t))
 
 	if err == nil {
@@ -546,7 +930,7 @@ func TestBuildADDFileNotFound(t *testing.T) {
 	ip := srv.runtime.networkManager.bridgeNetwork.IP
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false)
+	buildfile := NewBuildFile(srv, ioutil.Discard, false, true, false, false, false, nil, BuildLimits{})
 	_, err = buildfile.Build(mkTestContext(dockerfile, context.files, t))
 
 	if err == nil {
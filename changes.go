@@ -1,10 +1,13 @@
 package docker
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 type ChangeType int
@@ -104,3 +107,178 @@ func Changes(layers []string, rw string) ([]Change, error) {
 	}
 	return changes, nil
 }
+
+// ChangesDirs compares the filesystem tree at `newDir` with the one at `oldDir`
+// and returns the list of files which were added, modified or deleted.
+// If `oldDir` is empty, every file in `newDir` is reported as added.
+// Files are compared by their metadata only.
+func ChangesDirs(newDir, oldDir string) ([]Change, error) {
+	return changesDirs(newDir, oldDir, false)
+}
+
+// changesDirs is ChangesDirs, but with `compareContent` the files with the
+// same metadata are also compared by their content, eg. for a file rewritten
+// within the same second.
+func changesDirs(newDir, oldDir string, compareContent bool) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(newDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		path, err = filepath.Rel(newDir, path)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join("/", path)
+		if path == "/" {
+			return nil
+		}
+
+		change := Change{
+			Path: path,
+			Kind: ChangeAdd,
+		}
+		if oldDir != "" {
+			stat, err := os.Lstat(filepath.Join(oldDir, path))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil {
+				if sameFile(f, stat) {
+					if !compareContent {
+						return nil
+					}
+					same, err := sameContent(filepath.Join(newDir, path), filepath.Join(oldDir, path), f)
+					if err != nil {
+						return err
+					}
+					if same {
+						return nil
+					}
+				}
+				change.Kind = ChangeModify
+			}
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if oldDir == "" {
+		return changes, nil
+	}
+
+	// Look for the files which disappeared
+	err = filepath.Walk(oldDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		path, err = filepath.Rel(oldDir, path)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join("/", path)
+		if path == "/" {
+			return nil
+		}
+		if _, err := os.Lstat(filepath.Join(newDir, path)); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			changes = append(changes, Change{Path: path, Kind: ChangeDelete})
+			// No need to report the content of a deleted directory
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// sameFile returns true if two files look identical without reading their content.
+func sameFile(a, b os.FileInfo) bool {
+	if a.Mode() != b.Mode() {
+		return false
+	}
+	if statA, ok := a.Sys().(*syscall.Stat_t); ok {
+		statB := b.Sys().(*syscall.Stat_t)
+		if statA.Uid != statB.Uid || statA.Gid != statB.Gid {
+			return false
+		}
+		if a.Mode()&os.ModeDevice != 0 && statA.Rdev != statB.Rdev {
+			return false
+		}
+	}
+	// The mtime of a directory changes with its content: only its mode matters.
+	if a.IsDir() {
+		return true
+	}
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// sameContent returns true if the files `a` and `b`, which have the same
+// metadata `f`, have the same content, or the same target for symlinks.
+func sameContent(a, b string, f os.FileInfo) (bool, error) {
+	if f.Mode()&os.ModeSymlink != 0 {
+		targetA, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		targetB, err := os.Readlink(b)
+		if err != nil {
+			return false, err
+		}
+		return targetA == targetB, nil
+	}
+	if !f.Mode().IsRegular() {
+		return true, nil
+	}
+	fileA, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(fileA, bufA)
+		nB, errB := io.ReadFull(fileB, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// ChangesSize returns the size in bytes of the files added or modified in `changes`,
+// read from the filesystem tree at `dir`.
+func ChangesSize(dir string, changes []Change) int64 {
+	var size int64
+	for _, change := range changes {
+		if change.Kind == ChangeDelete {
+			continue
+		}
+		if stat, err := os.Lstat(filepath.Join(dir, change.Path)); err == nil && !stat.IsDir() {
+			size += stat.Size()
+		}
+	}
+	return size
+}
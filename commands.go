@@ -10,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/parser"
 	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/term"
 	"github.com/dotcloud/docker/utils"
@@ -88,6 +89,7 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"cp", "Copy files/folders from the containers filesystem to the host path"},
 		{"diff", "Inspect changes on a container's filesystem"},
 		{"events", "Get real time events from the server"},
+		{"exec", "Run a command in a running container"},
 		{"export", "Stream the contents of a container as a tar archive"},
 		{"history", "Show the history of an image"},
 		{"images", "List images"},
@@ -96,21 +98,28 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"insert", "Insert a file in an image"},
 		{"inspect", "Return low-level information on a container"},
 		{"kill", "Kill a running container"},
+		{"load", "Load an image from a tar archive"},
 		{"login", "Register or Login to the docker registry server"},
 		{"logs", "Fetch the logs of a container"},
+		{"pause", "Pause all processes within a container"},
 		{"port", "Lookup the public-facing port which is NAT-ed to PRIVATE_PORT"},
 		{"top", "Lookup the running processes of a container"},
 		{"ps", "List containers"},
 		{"pull", "Pull an image or a repository from the docker registry server"},
 		{"push", "Push an image or a repository to the docker registry server"},
+		{"rename", "Rename a container"},
 		{"restart", "Restart a running container"},
 		{"rm", "Remove one or more containers"},
 		{"rmi", "Remove one or more images"},
 		{"run", "Run a command in a new container"},
+		{"save", "Save an image to a tar archive"},
 		{"search", "Search for an image in the docker index"},
+		{"squash", "Merge the layers of an image into one"},
 		{"start", "Start a stopped container"},
+		{"stats", "Display a live stream of the resource usage of a container"},
 		{"stop", "Stop a running container"},
 		{"tag", "Tag an image into a repository"},
+		{"unpause", "Unpause a paused container"},
 		{"version", "Show the docker version information"},
 		{"wait", "Block until a container stops, then print its exit code"},
 	} {
@@ -165,12 +174,49 @@ func mkBuildContext(dockerfile string, files [][2]string) (Archive, error) {
 	return buf, nil
 }
 
+// checkDockerfile reports the syntax errors of the Dockerfile of a local
+// build context, or of the Dockerfile read from stdin for "-".
+func (cli *DockerCli) checkDockerfile(context string) error {
+	var dockerfile io.Reader
+	if context == "-" {
+		dockerfile = cli.in
+	} else if utils.IsURL(context) || utils.IsGIT(context) {
+		return fmt.Errorf("Only local Dockerfiles can be checked")
+	} else {
+		f, err := os.Open(filepath.Join(context, "Dockerfile"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dockerfile = f
+	}
+	_, err := parser.Parse(dockerfile)
+	if syntaxErrors, ok := err.(parser.ErrorList); ok {
+		for _, err := range syntaxErrors {
+			fmt.Fprintln(cli.err, err)
+		}
+		return &utils.StatusError{Status: 1}
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintln(cli.out, "Dockerfile OK")
+	return nil
+}
+
 func (cli *DockerCli) CmdBuild(args ...string) error {
 	cmd := Subcmd("build", "[OPTIONS] PATH | URL | -", "Build a new container image from the source code at PATH")
 	tag := cmd.String("t", "", "Repository name (and optionally a tag) to be applied to the resulting image in case of success")
 	suppressOutput := cmd.Bool("q", false, "Suppress verbose build output")
 	noCache := cmd.Bool("no-cache", false, "Do not use cache when building the image")
 	rm := cmd.Bool("rm", false, "Remove intermediate containers after a successful build")
+	check := cmd.Bool("check", false, "Only check the syntax of the Dockerfile, without building anything")
+	jsonStream := cmd.Bool("json", false, "Output the progress as a stream of JSON events")
+	memory := cmd.Int64("m", 0, "Memory limit of the intermediate containers (in bytes)")
+	cpuShares := cmd.Int64("c", 0, "CPU shares of the intermediate containers (relative weight)")
+	network := cmd.Bool("network", true, "Enable networking for the intermediate containers")
+	squash := cmd.Bool("squash", false, "Merge the layers added by the build into one")
+	var buildArgs ListOpts
+	cmd.Var(&buildArgs, "build-arg", "Set a build argument declared with ARG in the Dockerfile (KEY=VALUE, or KEY to use the local environment)")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -178,6 +224,9 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		cmd.Usage()
 		return nil
 	}
+	if *check {
+		return cli.checkDockerfile(cmd.Arg(0))
+	}
 
 	var (
 		context  Archive
@@ -199,7 +248,13 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		if _, err := os.Stat(cmd.Arg(0)); err != nil {
 			return err
 		}
-		context, err = Tar(cmd.Arg(0), Uncompressed)
+		excludes, err := ReadDockerIgnore(cmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		if context, err = TarFilter(cmd.Arg(0), &TarOptions{Excludes: excludes}); err != nil {
+			return err
+		}
 	}
 	var body io.Reader
 	// Setup an upload progress bar
@@ -224,6 +279,37 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	if *rm {
 		v.Set("rm", "1")
 	}
+	if *squash {
+		v.Set("squash", "1")
+	}
+	if *jsonStream {
+		v.Set("json", "1")
+	}
+	if *memory != 0 {
+		v.Set("memory", strconv.FormatInt(*memory, 10))
+	}
+	if *cpuShares != 0 {
+		v.Set("cpushares", strconv.FormatInt(*cpuShares, 10))
+	}
+	if !*network {
+		v.Set("networkdisabled", "1")
+	}
+	if len(buildArgs) > 0 {
+		args := make(map[string]string)
+		for _, arg := range buildArgs {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) == 2 {
+				args[parts[0]] = parts[1]
+			} else if value, exists := syscall.Getenv(arg); exists {
+				args[arg] = value
+			}
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		v.Set("buildargs", string(data))
+	}
 	req, err := http.NewRequest("POST", fmt.Sprintf("/v%g/build?%s", APIVERSION, v.Encode()), body)
 	if err != nil {
 		return err
@@ -391,6 +477,65 @@ func (cli *DockerCli) CmdWait(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdPause(args ...string) error {
+	cmd := Subcmd("pause", "CONTAINER [CONTAINER...]", "Pause all processes within a container")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	for _, name := range cmd.Args() {
+		_, _, err := cli.call("POST", "/containers/"+name+"/pause", nil)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	return nil
+}
+
+func (cli *DockerCli) CmdUnpause(args ...string) error {
+	cmd := Subcmd("unpause", "CONTAINER [CONTAINER...]", "Unpause a paused container")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	for _, name := range cmd.Args() {
+		_, _, err := cli.call("POST", "/containers/"+name+"/unpause", nil)
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	return nil
+}
+
+func (cli *DockerCli) CmdRename(args ...string) error {
+	cmd := Subcmd("rename", "CONTAINER NEW_NAME", "Rename a container")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 2 {
+		cmd.Usage()
+		return nil
+	}
+	v := url.Values{}
+	v.Set("name", cmd.Arg(1))
+	if _, _, err := cli.call("POST", "/containers/"+cmd.Arg(0)+"/rename?"+v.Encode(), nil); err != nil {
+		return err
+	}
+	return nil
+}
+
 // 'docker version': show version information
 func (cli *DockerCli) CmdVersion(args ...string) error {
 	cmd := Subcmd("version", "", "Show the docker version information.")
@@ -465,6 +610,9 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 
 	fmt.Fprintf(cli.out, "Containers: %d\n", out.Containers)
 	fmt.Fprintf(cli.out, "Images: %d\n", out.Images)
+	if out.Driver != "" {
+		fmt.Fprintf(cli.out, "Driver: %s\n", out.Driver)
+	}
 	if out.Debug || os.Getenv("DEBUG") != "" {
 		fmt.Fprintf(cli.out, "Debug mode (server): %v\n", out.Debug)
 		fmt.Fprintf(cli.out, "Debug mode (client): %v\n", os.Getenv("DEBUG") != "")
@@ -489,6 +637,12 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 	if !out.SwapLimit {
 		fmt.Fprintf(cli.err, "WARNING: No swap limit support\n")
 	}
+	if !out.Cpuset {
+		fmt.Fprintf(cli.err, "WARNING: No cpuset support\n")
+	}
+	if !out.BlkioWeight {
+		fmt.Fprintf(cli.err, "WARNING: No block IO weight support\n")
+	}
 	if !out.IPv4Forwarding {
 		fmt.Fprintf(cli.err, "WARNING: IPv4 forwarding is disabled.\n")
 	}
@@ -636,6 +790,77 @@ func (cli *DockerCli) CmdTop(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdStats(args ...string) error {
+	cmd := Subcmd("stats", "[OPTIONS] CONTAINER", "Display a live stream of the resource usage of a container")
+	noStream := cmd.Bool("no-stream", false, "Only display the first sample")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	v := url.Values{}
+	if *noStream {
+		v.Set("stream", "0")
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("/v%g/containers/%s/stats?%s", APIVERSION, cmd.Arg(0), v.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Docker-Client/"+VERSION)
+	req.Host = cli.addr
+	dial, err := net.Dial(cli.proto, cli.addr)
+	if err != nil {
+		if strings.Contains(err.Error(), "connection refused") {
+			return fmt.Errorf("Can't connect to docker daemon. Is 'docker -d' running on this host?")
+		}
+		return err
+	}
+	clientconn := httputil.NewClientConn(dial, nil)
+	resp, err := clientconn.Do(req)
+	defer clientconn.Close()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if len(body) == 0 {
+			return fmt.Errorf("Error: %s", http.StatusText(resp.StatusCode))
+		}
+		return fmt.Errorf("Error: %s", body)
+	}
+
+	// Each sample is flushed as it comes, so the width of the columns is fixed
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "CONTAINER\tCPU %%\tMEM USAGE / LIMIT\tMEM %%\tNET I/O\tBLOCK I/O\n")
+	dec := json.NewDecoder(resp.Body)
+	for {
+		stats := &APIStats{}
+		if err := dec.Decode(stats); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\n",
+			cmd.Arg(0),
+			stats.CPUPercent,
+			utils.HumanSize(int64(stats.MemoryUsage)), utils.HumanSize(int64(stats.MemoryLimit)),
+			stats.MemoryPercent,
+			utils.HumanSize(int64(stats.NetworkRxBytes)), utils.HumanSize(int64(stats.NetworkTxBytes)),
+			utils.HumanSize(int64(stats.BlkioRead)), utils.HumanSize(int64(stats.BlkioWrite)))
+		w.Flush()
+	}
+	w.Flush()
+	return nil
+}
+
 func (cli *DockerCli) CmdPort(args ...string) error {
 	cmd := Subcmd("port", "CONTAINER PRIVATE_PORT", "Lookup the public-facing port which is NAT-ed to PRIVATE_PORT")
 	if err := cmd.Parse(args); err != nil {
@@ -731,6 +956,9 @@ func (cli *DockerCli) CmdHistory(args ...string) error {
 		if out.Tags != nil {
 			out.ID = out.Tags[0]
 		}
+		if out.Squashed {
+			out.ID += " (squashed)"
+		}
 		fmt.Fprintf(w, "%s \t%s ago\t%s\n", out.ID, utils.HumanDuration(time.Now().Sub(time.Unix(out.Created, 0))), out.CreatedBy)
 	}
 	w.Flush()
@@ -1073,7 +1301,7 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 	}
 	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
 	if !*quiet {
-		fmt.Fprint(w, "ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPORTS")
+		fmt.Fprint(w, "ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPORTS\tNAME")
 		if *size {
 			fmt.Fprintln(w, "\tSIZE")
 		} else {
@@ -1084,9 +1312,9 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 	for _, out := range outs {
 		if !*quiet {
 			if *noTrunc {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t", out.ID, out.Image, out.Command, utils.HumanDuration(time.Now().Sub(time.Unix(out.Created, 0))), out.Status, displayablePorts(out.Ports))
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t", out.ID, out.Image, out.Command, utils.HumanDuration(time.Now().Sub(time.Unix(out.Created, 0))), out.Status, displayablePorts(out.Ports), out.Name)
 			} else {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t", utils.TruncateID(out.ID), out.Image, utils.Trunc(out.Command, 20), utils.HumanDuration(time.Now().Sub(time.Unix(out.Created, 0))), out.Status, displayablePorts(out.Ports))
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t", utils.TruncateID(out.ID), out.Image, utils.Trunc(out.Command, 20), utils.HumanDuration(time.Now().Sub(time.Unix(out.Created, 0))), out.Status, displayablePorts(out.Ports), out.Name)
 			}
 			if *size {
 				if out.SizeRootFs > 0 {
@@ -1154,6 +1382,38 @@ func (cli *DockerCli) CmdCommit(args ...string) error {
 	return nil
 }
 
+// 'docker squash': merge the layers of an image above one of its parents
+func (cli *DockerCli) CmdSquash(args ...string) error {
+	cmd := Subcmd("squash", "[OPTIONS] IMAGE [FROM_PARENT]", "Merge the layers of IMAGE above its parent FROM_PARENT into one. Without FROM_PARENT, all the layers are merged")
+	tag := cmd.String("t", "", "Repository name (and optionally a tag) to be applied to the resulting image")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 || cmd.NArg() > 2 {
+		cmd.Usage()
+		return nil
+	}
+
+	v := url.Values{}
+	v.Set("parent", cmd.Arg(1))
+	if *tag != "" {
+		repository, tag := utils.ParseRepositoryTag(*tag)
+		v.Set("repo", repository)
+		v.Set("tag", tag)
+	}
+	body, _, err := cli.call("POST", "/images/"+cmd.Arg(0)+"/squash?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	apiID := &APIID{}
+	if err := json.Unmarshal(body, apiID); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s\n", apiID.ID)
+	return nil
+}
+
 func (cli *DockerCli) CmdEvents(args ...string) error {
 	cmd := Subcmd("events", "[OPTIONS]", "Get real time events from the server")
 	since := cmd.String("since", "", "Show events previously created (used for polling).")
@@ -1194,6 +1454,40 @@ func (cli *DockerCli) CmdExport(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdSave(args ...string) error {
+	cmd := Subcmd("save", "IMAGE", "Save an image to a tar archive (streamed to stdout)")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	if err := cli.stream("GET", "/images/"+cmd.Arg(0)+"/get", nil, cli.out, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cli *DockerCli) CmdLoad(args ...string) error {
+	cmd := Subcmd("load", "", "Load an image from a tar archive on stdin")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	if err := cli.stream("POST", "/images/load", cli.in, cli.out, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdDiff(args ...string) error {
 	cmd := Subcmd("diff", "CONTAINER", "Inspect changes on a container's filesystem")
 	if err := cmd.Parse(args); err != nil {
@@ -1221,7 +1515,10 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 }
 
 func (cli *DockerCli) CmdLogs(args ...string) error {
-	cmd := Subcmd("logs", "CONTAINER", "Fetch the logs of a container")
+	cmd := Subcmd("logs", "[OPTIONS] CONTAINER", "Fetch the logs of a container")
+	follow := cmd.Bool("f", false, "Follow log output")
+	timestamps := cmd.Bool("t", false, "Show timestamps")
+	tail := cmd.String("tail", "all", "Output the specified number of lines at the end of logs")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -1230,7 +1527,17 @@ func (cli *DockerCli) CmdLogs(args ...string) error {
 		return nil
 	}
 
-	if err := cli.hijack("POST", "/containers/"+cmd.Arg(0)+"/attach?logs=1&stdout=1&stderr=1", false, nil, cli.out); err != nil {
+	v := url.Values{}
+	v.Set("stdout", "1")
+	v.Set("stderr", "1")
+	v.Set("tail", *tail)
+	if *follow {
+		v.Set("follow", "1")
+	}
+	if *timestamps {
+		v.Set("timestamps", "1")
+	}
+	if err := cli.stream("GET", "/containers/"+cmd.Arg(0)+"/logs?"+v.Encode(), nil, cli.out, nil); err != nil {
 		return err
 	}
 	return nil
@@ -1262,7 +1569,7 @@ func (cli *DockerCli) CmdAttach(args ...string) error {
 	}
 
 	if container.Config.Tty {
-		if err := cli.monitorTtySize(cmd.Arg(0)); err != nil {
+		if err := cli.monitorTtySize(cmd.Arg(0), false); err != nil {
 			utils.Debugf("Error monitoring tty size: %s", err)
 		}
 	}
@@ -1279,6 +1586,60 @@ func (cli *DockerCli) CmdAttach(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdExec(args ...string) error {
+	cmd := Subcmd("exec", "[OPTIONS] CONTAINER COMMAND [ARG...]", "Run a command in a running container")
+	flStdin := cmd.Bool("i", false, "Keep stdin open even if not attached")
+	flTty := cmd.Bool("t", false, "Allocate a pseudo-tty")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 2 {
+		cmd.Usage()
+		return nil
+	}
+
+	config := &ExecConfig{
+		Cmd:       cmd.Args()[1:],
+		Tty:       *flTty,
+		OpenStdin: *flStdin,
+	}
+	body, _, err := cli.call("POST", "/containers/"+cmd.Arg(0)+"/exec", config)
+	if err != nil {
+		return err
+	}
+	execResult := &APIID{}
+	if err := json.Unmarshal(body, execResult); err != nil {
+		return err
+	}
+
+	if config.Tty {
+		if err := cli.monitorTtySize(execResult.ID, true); err != nil {
+			utils.Debugf("Error monitoring tty size: %s", err)
+		}
+	}
+
+	var in io.ReadCloser
+	if config.OpenStdin {
+		in = cli.in
+	}
+	if err := cli.hijack("POST", "/exec/"+execResult.ID+"/start", config.Tty, in, cli.out); err != nil {
+		return err
+	}
+
+	body, _, err = cli.call("GET", "/exec/"+execResult.ID+"/json", nil)
+	if err != nil {
+		return err
+	}
+	execInfo := &APIExec{}
+	if err := json.Unmarshal(body, execInfo); err != nil {
+		return err
+	}
+	if execInfo.ExitCode != 0 {
+		return &utils.StatusError{Status: execInfo.ExitCode}
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdSearch(args ...string) error {
 	cmd := Subcmd("search", "NAME", "Search the docker index for images")
 	noTrunc := cmd.Bool("notrunc", false, "Don't truncate output")
@@ -1445,8 +1806,13 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		defer containerIDFile.Close()
 	}
 
+	containerValues := url.Values{}
+	if name := cmd.Lookup("name").Value.String(); name != "" {
+		containerValues.Set("name", name)
+	}
+
 	//create the container
-	body, statusCode, err := cli.call("POST", "/containers/create", config)
+	body, statusCode, err := cli.call("POST", "/containers/create?"+containerValues.Encode(), config)
 	//if image not found try to pull it
 	if statusCode == 404 {
 		_, tag := utils.ParseRepositoryTag(config.Image)
@@ -1487,7 +1853,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		if err != nil {
 			return err
 		}
-		body, _, err = cli.call("POST", "/containers/create", config)
+		body, _, err = cli.call("POST", "/containers/create?"+containerValues.Encode(), config)
 		if err != nil {
 			return err
 		}
@@ -1529,7 +1895,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 
 	if config.AttachStdin || config.AttachStdout || config.AttachStderr {
 		if config.Tty {
-			if err := cli.monitorTtySize(runResult.ID); err != nil {
+			if err := cli.monitorTtySize(runResult.ID, false); err != nil {
 				utils.Debugf("Error monitoring TTY size: %s\n", err)
 			}
 		}
@@ -1612,7 +1978,7 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 
 	if statusCode == 200 {
 		r := bytes.NewReader(data)
-		if err := Untar(r, copyData.HostPath); err != nil {
+		if err := Untar(r, copyData.HostPath, nil); err != nil {
 			return err
 		}
 	}
@@ -1821,7 +2187,9 @@ func (cli *DockerCli) getTtySize() (int, int) {
 	return int(ws.Height), int(ws.Width)
 }
 
-func (cli *DockerCli) resizeTty(id string) {
+// resizeTty resizes the tty of the container id, or of the exec id if
+// isExec is set, to the size of the terminal.
+func (cli *DockerCli) resizeTty(id string, isExec bool) {
 	height, width := cli.getTtySize()
 	if height == 0 && width == 0 {
 		return
@@ -1829,22 +2197,26 @@ func (cli *DockerCli) resizeTty(id string) {
 	v := url.Values{}
 	v.Set("h", strconv.Itoa(height))
 	v.Set("w", strconv.Itoa(width))
-	if _, _, err := cli.call("POST", "/containers/"+id+"/resize?"+v.Encode(), nil); err != nil {
+	path := "/containers/" + id + "/resize?"
+	if isExec {
+		path = "/exec/" + id + "/resize?"
+	}
+	if _, _, err := cli.call("POST", path+v.Encode(), nil); err != nil {
 		utils.Debugf("Error resize: %s", err)
 	}
 }
 
-func (cli *DockerCli) monitorTtySize(id string) error {
+func (cli *DockerCli) monitorTtySize(id string, isExec bool) error {
 	if !cli.isTerminal {
 		return fmt.Errorf("Impossible to monitor size on non-tty")
 	}
-	cli.resizeTty(id)
+	cli.resizeTty(id, isExec)
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGWINCH)
 	go func() {
 		for _ = range sigchan {
-			cli.resizeTty(id)
+			cli.resizeTty(id, isExec)
 		}
 	}()
 	return nil
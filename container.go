@@ -25,7 +25,8 @@ import (
 type Container struct {
 	root string
 
-	ID string
+	ID   string
+	Name string
 
 	Created time.Time
 
@@ -39,6 +40,9 @@ type Container struct {
 	network         *NetworkInterface
 	NetworkSettings *NetworkSettings
 
+	// Path of the root filesystem, as returned by the storage driver
+	rootfs string
+
 	SysInitPath    string
 	ResolvConfPath string
 	HostnamePath   string
@@ -50,11 +54,23 @@ type Container struct {
 	stdin     io.ReadCloser
 	stdinPipe io.WriteCloser
 	ptyMaster io.Closer
+	// Variables describing the links of the container, set on start
+	linksEnv []string
+	// Capabilities dropped in the container, set with its lxc config
+	capDrop []string
+	// Devices of the host exposed in the container, set with its lxc config
+	devices []*device
+	// Host config of the container, set with its lxc config
+	hostConfig *HostConfig
 
 	runtime *Runtime
 
 	waitLock chan struct{}
-	Volumes  map[string]string
+	// Set when the container is stopped on purpose, so that its restart
+	// policy doesn't bring it back up
+	manuallyStopped bool
+
+	Volumes map[string]string
 	// Store rw/ro in a separate structure to preserve reverse-compatibility on-disk.
 	// Easier than migrating older container configs :)
 	VolumesRW map[string]bool
@@ -64,9 +80,12 @@ type Config struct {
 	Hostname        string
 	Domainname      string
 	User            string
-	Memory          int64 // Memory limit (in bytes)
-	MemorySwap      int64 // Total memory usage (memory + swap); set `-1' to disable swap
-	CpuShares       int64 // CPU shares (relative weight vs. other containers)
+	Memory          int64  // Memory limit (in bytes)
+	MemorySwap      int64  // Total memory usage (memory + swap); set `-1' to disable swap
+	CpuShares       int64  // CPU shares (relative weight vs. other containers)
+	Cpuset          string // CPUs in which the container can run, eg. "0-2,4"
+	BlkioWeight     int64  // Block IO weight (relative weight from 10 to 1000)
+	Ulimits         []Ulimit
 	AttachStdin     bool
 	AttachStdout    bool
 	AttachStderr    bool
@@ -84,12 +103,75 @@ type Config struct {
 	Entrypoint      []string
 	NetworkDisabled bool
 	Privileged      bool
+	// Instructions run by the builds using this image as base image, eg.
+	// "ADD . /app". They are not part of the images built from it.
+	OnBuild []string
 }
 
 type HostConfig struct {
 	Binds           []string
 	ContainerIDFile string
 	LxcConf         []KeyValuePair
+	Links           []string
+	RestartPolicy   RestartPolicy
+	LogConfig       LogConfig
+	// Capabilities kept or dropped on top of the default ones, eg. NET_ADMIN
+	CapAdd  []string
+	CapDrop []string
+	Devices []DeviceMapping
+	// Mount the root filesystem read-only. The volumes stay writable.
+	ReadonlyRootfs bool
+	// Paths of the tmpfs mounts of the container, with their options
+	Tmpfs map[string]string
+}
+
+// LogConfig selects the log driver receiving the output of a container
+// (json-file, syslog or none) and its options. The json-file driver rotates
+// the log once it reaches MaxSize bytes, keeping at most MaxFiles files.
+// The syslog driver sends the logs to SyslogAddress. Zero values fall back
+// to DefaultLogConfig.
+type LogConfig struct {
+	Type          string
+	MaxSize       int64
+	MaxFiles      int
+	SyslogAddress string
+}
+
+// DefaultLogConfig applies to the containers which don't set their own
+// log options. By default, logs are written to never rotated json files.
+var DefaultLogConfig = LogConfig{Type: "json-file", MaxFiles: 1}
+
+// RestartPolicy tells what to do when the process of a container exits:
+// "no" (or empty) leaves the container stopped, "always" restarts it, and
+// "on-failure" restarts it when it exits with a non-zero code, at most
+// MaximumRetryCount times (0 meaning no limit).
+type RestartPolicy struct {
+	Name              string
+	MaximumRetryCount int
+}
+
+// ParseRestartPolicy parses the value of the -restart flag:
+// no, always or on-failure[:max-retries]
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	p := RestartPolicy{Name: parts[0]}
+	switch p.Name {
+	case "", "no", "always":
+		if len(parts) > 1 {
+			return RestartPolicy{}, fmt.Errorf("Maximum retry count is only supported by the on-failure restart policy")
+		}
+	case "on-failure":
+		if len(parts) > 1 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return RestartPolicy{}, fmt.Errorf("Invalid maximum retry count: %s", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return RestartPolicy{}, fmt.Errorf("Invalid restart policy: %s", p.Name)
+	}
+	return p, nil
 }
 
 type BindMap struct {
@@ -126,6 +208,7 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	flContainerIDFile := cmd.String("cidfile", "", "Write the container ID to the file")
 	flNetwork := cmd.Bool("n", true, "Enable networking for this container")
 	flPrivileged := cmd.Bool("privileged", false, "Give extended privileges to this container")
+	cmd.String("name", "", "Assign a name to the container")
 
 	if capabilities != nil && *flMemory > 0 && !capabilities.MemoryLimit {
 		//fmt.Fprintf(stdout, "WARNING: Your kernel does not support memory limit capabilities. Limitation discarded.\n")
@@ -133,6 +216,11 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	}
 
 	flCpuShares := cmd.Int64("c", 0, "CPU shares (relative weight)")
+	flCpuset := cmd.String("cpuset", "", "CPUs in which the container can run (eg. 0-2,4)")
+	flBlkioWeight := cmd.Int64("blkio-weight", 0, "Block IO weight (relative weight from 10 to 1000)")
+
+	var flUlimits ListOpts
+	cmd.Var(&flUlimits, "ulimit", "Set a resource limit of the processes (eg. nofile=1024:2048)")
 
 	var flPorts ListOpts
 	cmd.Var(&flPorts, "p", "Expose a container's port to the host (use 'docker port' to see the actual mapping)")
@@ -154,6 +242,30 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	var flLxcOpts ListOpts
 	cmd.Var(&flLxcOpts, "lxc-conf", "Add custom lxc options -lxc-conf=\"lxc.cgroup.cpuset.cpus = 0,1\"")
 
+	var flLinks ListOpts
+	cmd.Var(&flLinks, "link", "Add a link to another container (name:alias)")
+
+	flLogDriver := cmd.String("log-driver", "", "Log driver for the container (json-file, syslog, none), defaults to the one of the daemon")
+	flLogSyslogAddress := cmd.String("log-syslog-address", "", "Address of the syslog daemon for the syslog log driver (unix:///path or udp://host:port)")
+	flLogMaxSize := cmd.String("log-max-size", "", "Size of the container log at which it is rotated (eg. 10m), unlimited by default")
+	flLogMaxFiles := cmd.Int("log-max-files", 0, "Maximum number of log files kept when rotating, including the current one")
+
+	flRestartPolicy := cmd.String("restart", "no", "Restart policy to apply when the container exits (no, always, on-failure[:max-retries])")
+
+	var flCapAdd ListOpts
+	cmd.Var(&flCapAdd, "cap-add", "Add a Linux capability (eg. NET_ADMIN, or ALL)")
+
+	var flCapDrop ListOpts
+	cmd.Var(&flCapDrop, "cap-drop", "Drop a Linux capability (eg. CHOWN, or ALL)")
+
+	var flDevices ListOpts
+	cmd.Var(&flDevices, "device", "Add a device of the host to the container (/dev/host[:/dev/container[:rwm]])")
+
+	flReadonlyRootfs := cmd.Bool("read-only", false, "Mount the root filesystem of the container read-only")
+
+	var flTmpfs ListOpts
+	cmd.Var(&flTmpfs, "tmpfs", "Mount a tmpfs directory (eg. /run:size=64m)")
+
 	if err := cmd.Parse(args); err != nil {
 		return nil, nil, cmd, err
 	}
@@ -174,6 +286,58 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		}
 	}
 
+	for _, link := range flLinks {
+		if _, _, err := parseLink(link); err != nil {
+			return nil, nil, cmd, err
+		}
+	}
+
+	restartPolicy, err := ParseRestartPolicy(*flRestartPolicy)
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+
+	if *flLogDriver != "" {
+		if err := ValidateLogDriver(*flLogDriver); err != nil {
+			return nil, nil, cmd, err
+		}
+	}
+	logConfig := LogConfig{
+		Type:          *flLogDriver,
+		MaxFiles:      *flLogMaxFiles,
+		SyslogAddress: *flLogSyslogAddress,
+	}
+	if *flLogMaxSize != "" {
+		if logConfig.MaxSize, err = utils.RAMInBytes(*flLogMaxSize); err != nil {
+			return nil, nil, cmd, err
+		}
+	}
+	if logConfig.MaxFiles < 0 {
+		return nil, nil, cmd, fmt.Errorf("Invalid maximum number of log files: %d", logConfig.MaxFiles)
+	}
+
+	if _, err := droppedCapabilities(*flPrivileged, flCapAdd, flCapDrop); err != nil {
+		return nil, nil, cmd, err
+	}
+
+	var ulimits []Ulimit
+	for _, value := range flUlimits {
+		ulimit, err := parseUlimit(value)
+		if err != nil {
+			return nil, nil, cmd, err
+		}
+		ulimits = append(ulimits, *ulimit)
+	}
+
+	var devices []DeviceMapping
+	for _, spec := range flDevices {
+		mapping, err := parseDevice(spec)
+		if err != nil {
+			return nil, nil, cmd, err
+		}
+		devices = append(devices, mapping)
+	}
+
 	var binds []string
 
 	// add any bind targets to the list of container volumes
@@ -187,6 +351,24 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		}
 	}
 
+	var tmpfs map[string]string
+	for _, value := range flTmpfs {
+		dst, options, err := parseTmpfs(value)
+		if err != nil {
+			return nil, nil, cmd, err
+		}
+		if tmpfs == nil {
+			tmpfs = make(map[string]string)
+		}
+		if _, exists := tmpfs[path.Clean(dst)]; exists {
+			return nil, nil, cmd, fmt.Errorf("Conflicting options: -tmpfs %s is given twice", dst)
+		}
+		tmpfs[path.Clean(dst)] = options
+	}
+	if err := validateTmpfs(tmpfs, flVolumes, devices); err != nil {
+		return nil, nil, cmd, err
+	}
+
 	parsedArgs := cmd.Args()
 	runCmd := []string{}
 	entrypoint := []string{}
@@ -201,7 +383,6 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		entrypoint = []string{*flEntrypoint}
 	}
 
-	var lxcConf []KeyValuePair
 	lxcConf, err := parseLxcConfOpts(flLxcOpts)
 	if err != nil {
 		return nil, nil, cmd, err
@@ -225,6 +406,9 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		OpenStdin:       *flStdin,
 		Memory:          *flMemory,
 		CpuShares:       *flCpuShares,
+		Cpuset:          *flCpuset,
+		BlkioWeight:     *flBlkioWeight,
+		Ulimits:         ulimits,
 		AttachStdin:     flAttach.Get("stdin"),
 		AttachStdout:    flAttach.Get("stdout"),
 		AttachStderr:    flAttach.Get("stderr"),
@@ -242,12 +426,29 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		Binds:           binds,
 		ContainerIDFile: *flContainerIDFile,
 		LxcConf:         lxcConf,
+		Links:           flLinks,
+		RestartPolicy:   restartPolicy,
+		LogConfig:       logConfig,
+		CapAdd:          flCapAdd,
+		CapDrop:         flCapDrop,
+		Devices:         devices,
+		ReadonlyRootfs:  *flReadonlyRootfs,
+		Tmpfs:           tmpfs,
 	}
 
 	if capabilities != nil && *flMemory > 0 && !capabilities.SwapLimit {
 		//fmt.Fprintf(stdout, "WARNING: Your kernel does not support swap limit capabilities. Limitation discarded.\n")
 		config.MemorySwap = -1
 	}
+	if err := validateResources(config); err != nil {
+		return nil, nil, cmd, err
+	}
+	if capabilities != nil && config.Cpuset != "" && !capabilities.Cpuset {
+		config.Cpuset = ""
+	}
+	if capabilities != nil && config.BlkioWeight > 0 && !capabilities.BlkioWeight {
+		config.BlkioWeight = 0
+	}
 
 	// When allocating stdin in attached mode, close stdin at client disconnect
 	if config.OpenStdin && config.AttachStdin {
@@ -292,12 +493,15 @@ func (settings *NetworkSettings) PortMappingAPI() []APIPort {
 
 // Inject the io.Reader at the given path. Note: do not close the reader
 func (container *Container) Inject(file io.Reader, pth string) error {
+	if err := container.EnsureMounted(); err != nil {
+		return err
+	}
 	// Make sure the directory exists
-	if err := os.MkdirAll(path.Join(container.rwPath(), path.Dir(pth)), 0755); err != nil {
+	if err := os.MkdirAll(path.Join(container.RootfsPath(), path.Dir(pth)), 0755); err != nil {
 		return err
 	}
 	// FIXME: Handle permissions/already existing dest
-	dest, err := os.Create(path.Join(container.rwPath(), pth))
+	dest, err := os.Create(path.Join(container.RootfsPath(), pth))
 	if err != nil {
 		return err
 	}
@@ -356,7 +560,52 @@ func (container *Container) SaveHostConfig(hostConfig *HostConfig) (err error) {
 	return ioutil.WriteFile(container.hostConfigPath(), data, 0666)
 }
 
+// writeHostsFile generates the /etc/hosts of the container, including an
+// entry for each of its links.
+func (container *Container) writeHostsFile(links []*Link) error {
+	hostsContent := []byte(`
+127.0.0.1	localhost
+::1		localhost ip6-localhost ip6-loopback
+fe00::0		ip6-localnet
+ff00::0		ip6-mcastprefix
+ff02::1		ip6-allnodes
+ff02::2		ip6-allrouters
+`)
+
+	if container.Config.Domainname != "" {
+		hostsContent = append([]byte(fmt.Sprintf("::1\t\t%s.%s %s\n", container.Config.Hostname, container.Config.Domainname, container.Config.Hostname)), hostsContent...)
+		hostsContent = append([]byte(fmt.Sprintf("127.0.0.1\t%s.%s %s\n", container.Config.Hostname, container.Config.Domainname, container.Config.Hostname)), hostsContent...)
+	} else {
+		hostsContent = append([]byte(fmt.Sprintf("::1\t\t%s\n", container.Config.Hostname)), hostsContent...)
+		hostsContent = append([]byte(fmt.Sprintf("127.0.0.1\t%s\n", container.Config.Hostname)), hostsContent...)
+	}
+
+	for _, link := range links {
+		hostsContent = append(hostsContent, []byte(fmt.Sprintf("%s\t%s\n", link.IP, link.Alias))...)
+	}
+	return ioutil.WriteFile(container.HostsPath, hostsContent, 0644)
+}
+
 func (container *Container) generateLXCConfig(hostConfig *HostConfig) error {
+	if hostConfig == nil {
+		hostConfig = &HostConfig{}
+	}
+	container.hostConfig = hostConfig
+	dropped, err := droppedCapabilities(container.Config.Privileged, hostConfig.CapAdd, hostConfig.CapDrop)
+	if err != nil {
+		return err
+	}
+	container.capDrop = dropped
+	container.devices = nil
+	for i, mapping := range hostConfig.Devices {
+		d, err := resolveDevice(mapping)
+		if err != nil {
+			return err
+		}
+		d.NodePath = path.Join(container.devicesPath(), strconv.Itoa(i))
+		container.devices = append(container.devices, d)
+	}
+
 	fo, err := os.Create(container.lxcConfigPath())
 	if err != nil {
 		return err
@@ -365,12 +614,7 @@ func (container *Container) generateLXCConfig(hostConfig *HostConfig) error {
 	if err := LxcTemplateCompiled.Execute(fo, container); err != nil {
 		return err
 	}
-	if hostConfig != nil {
-		if err := LxcHostConfigTemplateCompiled.Execute(fo, hostConfig); err != nil {
-			return err
-		}
-	}
-	return nil
+	return LxcHostConfigTemplateCompiled.Execute(fo, hostConfig)
 }
 
 func (container *Container) startPty() error {
@@ -401,7 +645,7 @@ func (container *Container) startPty() error {
 			utils.Debugf("[startPty] End of stdin pipe")
 		}()
 	}
-	if err := container.cmd.Start(); err != nil {
+	if err := startWithUlimits(container.cmd, container.Config.Ulimits); err != nil {
 		return err
 	}
 	ptySlave.Close()
@@ -423,7 +667,7 @@ func (container *Container) start() error {
 			utils.Debugf("End of stdin pipe [start]")
 		}()
 	}
-	return container.cmd.Start()
+	return startWithUlimits(container.cmd, container.Config.Ulimits)
 }
 
 func (container *Container) Attach(stdin io.ReadCloser, stdinCloser io.Closer, stdout io.Writer, stderr io.Writer) chan error {
@@ -565,7 +809,11 @@ func (container *Container) Attach(stdin io.ReadCloser, stdinCloser io.Closer, s
 func (container *Container) Start(hostConfig *HostConfig) error {
 	container.State.Lock()
 	defer container.State.Unlock()
+	return container.startLocked(hostConfig)
+}
 
+// startLocked starts the container, whose state must be locked.
+func (container *Container) startLocked(hostConfig *HostConfig) error {
 	if hostConfig == nil { // in docker start of docker restart we want to reuse previous HostConfigFile
 		hostConfig, _ = container.ReadHostConfig()
 	}
@@ -573,6 +821,7 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 	if container.State.Running {
 		return fmt.Errorf("The container %s is already running.", container.ID)
 	}
+	container.manuallyStopped = false
 	if err := container.EnsureMounted(); err != nil {
 		return err
 	}
@@ -593,6 +842,14 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 		log.Printf("WARNING: Your kernel does not support swap limit capabilities. Limitation discarded.\n")
 		container.Config.MemorySwap = -1
 	}
+	if container.Config.Cpuset != "" && !container.runtime.capabilities.Cpuset {
+		log.Printf("WARNING: Your kernel does not support cpuset. Limitation discarded.\n")
+		container.Config.Cpuset = ""
+	}
+	if container.Config.BlkioWeight > 0 && !container.runtime.capabilities.BlkioWeight {
+		log.Printf("WARNING: Your kernel does not support block IO weight. Limitation discarded.\n")
+		container.Config.BlkioWeight = 0
+	}
 
 	if container.runtime.capabilities.IPv4ForwardingDisabled {
 		log.Printf("WARNING: IPv4 forwarding is disabled. Networking will not work")
@@ -686,7 +943,7 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 			if err != nil {
 				return err
 			}
-			srcPath, err = c.layer()
+			srcPath, err = container.runtime.volumes.driver.Get(c.ID)
 			if err != nil {
 				return err
 			}
@@ -737,9 +994,52 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 		}
 	}
 
+	// Create the tmpfs mountpoints, which can't hide the volumes
+	volumes := make(map[string]struct{})
+	for volPath := range container.Volumes {
+		volumes[volPath] = struct{}{}
+	}
+	if err := validateTmpfs(hostConfig.Tmpfs, volumes, hostConfig.Devices); err != nil {
+		return err
+	}
+	for dst := range hostConfig.Tmpfs {
+		mountpoint, err := resolveInDir(container.RootfsPath(), dst)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(mountpoint, 0755); err != nil {
+			return err
+		}
+	}
+
+	links, err := container.setupLinks(hostConfig)
+	if err != nil {
+		return err
+	}
+	if err := container.writeHostsFile(links); err != nil {
+		return err
+	}
+
 	if err := container.generateLXCConfig(hostConfig); err != nil {
 		return err
 	}
+	// The device nodes are bind mounted, so that they stay out of the rw layer
+	if err := os.RemoveAll(container.devicesPath()); err != nil {
+		return err
+	}
+	if len(container.devices) > 0 {
+		if err := os.MkdirAll(container.devicesPath(), 0700); err != nil {
+			return err
+		}
+	}
+	for _, d := range container.devices {
+		if err := d.createNode(d.NodePath); err != nil {
+			return err
+		}
+		if err := d.createMountpoint(container.RootfsPath()); err != nil {
+			return err
+		}
+	}
 
 	params := []string{
 		"-n", container.ID,
@@ -758,17 +1058,23 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 		params = append(params, "-u", container.Config.User)
 	}
 
+	// Resource limits, set by dockerinit
+	for _, ulimit := range container.Config.Ulimits {
+		params = append(params, "-ulimit", ulimit.String())
+	}
+
 	if container.Config.Tty {
 		params = append(params, "-e", "TERM=xterm")
 	}
 
 	// Setup environment
-	params = append(params,
-		"-e", "HOME=/",
-		"-e", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
-		"-e", "container=lxc",
-		"-e", "HOSTNAME="+container.Config.Hostname,
-	)
+	container.linksEnv = nil
+	for _, link := range links {
+		container.linksEnv = append(container.linksEnv, link.ToEnv()...)
+	}
+	for _, elem := range container.env() {
+		params = append(params, "-e", elem)
+	}
 	if container.Config.WorkingDir != "" {
 		workingDir := path.Clean(container.Config.WorkingDir)
 		utils.Debugf("[working dir] working dir is %s", workingDir)
@@ -782,25 +1088,17 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 		)
 	}
 
-	for _, elem := range container.Config.Env {
-		params = append(params, "-e", elem)
-	}
-
 	// Program
 	params = append(params, "--", container.Path)
 	params = append(params, container.Args...)
 
 	container.cmd = exec.Command("lxc-start", params...)
 
-	// Setup logging of stdout and stderr to disk
-	if err := container.runtime.LogToDisk(container.stdout, container.logPath("json"), "stdout"); err != nil {
-		return err
-	}
-	if err := container.runtime.LogToDisk(container.stderr, container.logPath("json"), "stderr"); err != nil {
+	// Send stdout and stderr to the log driver
+	if err := container.runtime.StartLogging(container, hostConfig.LogConfig); err != nil {
 		return err
 	}
 
-	var err error
 	if container.Config.Tty {
 		err = container.startPty()
 	} else {
@@ -822,6 +1120,54 @@ func (container *Container) Start(hostConfig *HostConfig) error {
 	return nil
 }
 
+// env returns the environment of the processes of the container, apart
+// from TERM which depends on their tty.
+func (container *Container) env() []string {
+	env := []string{
+		"HOME=/",
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"container=lxc",
+		"HOSTNAME=" + container.Config.Hostname,
+	}
+	env = append(env, container.linksEnv...)
+	return append(env, container.Config.Env...)
+}
+
+// setupLinks resolves the links requested in hostConfig to running
+// containers, and records them in the link graph of the runtime.
+// A link whose container was renamed since it was established is
+// resolved through the link graph.
+func (container *Container) setupLinks(hostConfig *HostConfig) ([]*Link, error) {
+	var links []*Link
+	for _, l := range hostConfig.Links {
+		name, alias, err := parseLink(l)
+		if err != nil {
+			return nil, err
+		}
+		child := container.runtime.Get(name)
+		if child == nil {
+			if id := container.runtime.links.Child(container.ID, alias); id != "" {
+				child = container.runtime.Get(id)
+			}
+		}
+		if child == nil {
+			return nil, fmt.Errorf("Could not find container for link %s: no such container: %s", alias, name)
+		}
+		if child.ID == container.ID {
+			return nil, fmt.Errorf("Cannot link a container to itself")
+		}
+		link, err := NewLink(child, alias)
+		if err != nil {
+			return nil, err
+		}
+		if err := container.runtime.links.Add(container.ID, alias, child.ID); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
 func (container *Container) Run() error {
 	hostConfig := &HostConfig{}
 	if err := container.Start(hostConfig); err != nil {
@@ -972,6 +1318,10 @@ func (container *Container) monitor() {
 
 	// Report status back
 	container.State.setStopped(exitCode)
+	if container.runtime != nil {
+		// The processes started by docker exec died with the container
+		container.runtime.execs.DeleteContainer(container.ID)
+	}
 
 	if container.runtime != nil && container.runtime.srv != nil {
 		container.runtime.srv.LogEvent("die", container.ShortID(), container.runtime.repositories.ImageName(container.Image))
@@ -1018,6 +1368,79 @@ func (container *Container) monitor() {
 		// FIXME: why are we serializing running state to disk in the first place?
 		//log.Printf("%s: Failed to dump configuration to the disk: %s", container.ID, err)
 	}
+
+	if container.shouldRestart(exitCode) {
+		go container.autoRestart()
+	}
+}
+
+const (
+	restartBackoffStart = 100 * time.Millisecond
+	restartBackoffMax   = time.Minute
+)
+
+// shouldRestart tells whether the restart policy of the container asks for
+// it to be restarted after its process exited with exitCode.
+func (container *Container) shouldRestart(exitCode int) bool {
+	container.State.Lock()
+	defer container.State.Unlock()
+	if container.manuallyStopped || container.runtime == nil {
+		return false
+	}
+	hostConfig, err := container.ReadHostConfig()
+	if err != nil {
+		return false
+	}
+	policy := hostConfig.RestartPolicy
+	switch policy.Name {
+	case "always":
+		return true
+	case "on-failure":
+		if exitCode == 0 {
+			return false
+		}
+		return policy.MaximumRetryCount == 0 || container.State.RestartCount < policy.MaximumRetryCount
+	}
+	return false
+}
+
+// autoRestart starts the container again after a delay which doubles with
+// each restart, so that a container which keeps failing doesn't hog the host.
+func (container *Container) autoRestart() {
+	container.State.Lock()
+	restartCount := container.State.RestartCount
+	container.State.Unlock()
+	delay := restartBackoffStart
+	for i := 0; i < restartCount && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	utils.Debugf("%s: Restarting in %s", container.ID, delay)
+	time.Sleep(delay)
+
+	// The container may have been stopped or destroyed in the meantime: the
+	// state stays locked until it is started, so that Stop and Kill can't
+	// be missed
+	if container.runtime.Get(container.ID) != container {
+		return
+	}
+	container.State.Lock()
+	if container.manuallyStopped || container.State.Running {
+		container.State.Unlock()
+		return
+	}
+	container.State.RestartCount++
+	err := container.startLocked(nil)
+	container.State.Unlock()
+	if err != nil {
+		log.Printf("%s: Failed to restart container: %s", container.ID, err)
+		return
+	}
+	if container.runtime.srv != nil {
+		container.runtime.srv.LogEvent("restart", container.ShortID(), container.runtime.repositories.ImageName(container.Image))
+	}
 }
 
 func (container *Container) kill() error {
@@ -1050,18 +1473,34 @@ func (container *Container) kill() error {
 func (container *Container) Kill() error {
 	container.State.Lock()
 	defer container.State.Unlock()
+	container.manuallyStopped = true
 	if !container.State.Running {
 		return nil
 	}
+	// Frozen processes can't handle signals
+	if container.State.Paused {
+		if err := container.setFreezerState("THAWED"); err != nil {
+			return err
+		}
+		container.State.Paused = false
+	}
 	return container.kill()
 }
 
 func (container *Container) Stop(seconds int) error {
 	container.State.Lock()
 	defer container.State.Unlock()
+	container.manuallyStopped = true
 	if !container.State.Running {
 		return nil
 	}
+	// Frozen processes can't handle signals
+	if container.State.Paused {
+		if err := container.setFreezerState("THAWED"); err != nil {
+			return err
+		}
+		container.State.Paused = false
+	}
 
 	// 1. Send a SIGTERM
 	if output, err := exec.Command("lxc-kill", "-n", container.ID, "15").CombinedOutput(); err != nil {
@@ -1082,15 +1521,71 @@ func (container *Container) Stop(seconds int) error {
 	return nil
 }
 
-func (container *Container) Restart(seconds int) error {
-	if err := container.Stop(seconds); err != nil {
+// Pause freezes all the processes of the container with the freezer cgroup.
+func (container *Container) Pause() error {
+	container.State.Lock()
+	defer container.State.Unlock()
+	if !container.State.Running {
+		return fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if container.State.Paused {
+		return fmt.Errorf("Container %s is already paused", container.ID)
+	}
+	if err := container.setFreezerState("FROZEN"); err != nil {
 		return err
 	}
-	hostConfig := &HostConfig{}
-	if err := container.Start(hostConfig); err != nil {
+	container.State.Paused = true
+	return container.ToDisk()
+}
+
+// Unpause resumes the processes of a paused container.
+func (container *Container) Unpause() error {
+	container.State.Lock()
+	defer container.State.Unlock()
+	if !container.State.Paused {
+		return fmt.Errorf("Container %s is not paused", container.ID)
+	}
+	if err := container.setFreezerState("THAWED"); err != nil {
 		return err
 	}
-	return nil
+	container.State.Paused = false
+	return container.ToDisk()
+}
+
+// setFreezerState writes state (FROZEN or THAWED) to the freezer cgroup of
+// the container, and waits for the kernel to reach it: freezing can take
+// a while when processes are in uninterruptible sleep.
+func (container *Container) setFreezerState(state string) error {
+	mountpoint, err := utils.FindCgroupMountpoint("freezer")
+	if err != nil {
+		return err
+	}
+	statePath := path.Join(mountpoint, "lxc", container.ID, "freezer.state")
+	if err := ioutil.WriteFile(statePath, []byte(state), 0644); err != nil {
+		return fmt.Errorf("Unable to set the freezer state of %s to %s: %s", container.ID, state, err)
+	}
+	for i := 0; i < 100; i++ {
+		current, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("Timeout waiting for container %s to be %s", container.ID, state)
+}
+
+func (container *Container) Restart(seconds int) error {
+	if err := container.Stop(seconds); err != nil {
+		return err
+	}
+	container.State.Lock()
+	defer container.State.Unlock()
+	container.State.RestartCount = 0
+	// Keep the host config of the previous run, including its restart policy
+	return container.startLocked(nil)
 }
 
 // Wait blocks until the container stops running, then returns its exit code.
@@ -1108,11 +1603,24 @@ func (container *Container) Resize(h, w int) error {
 }
 
 func (container *Container) ExportRw() (Archive, error) {
-	return Tar(container.rwPath(), Uncompressed)
+	if container.runtime == nil {
+		return nil, fmt.Errorf("Can't export the rw layer of unregistered container")
+	}
+	archive, err := container.runtime.driver.Diff(container.ID, container.initLayerID())
+	if err != nil {
+		return nil, err
+	}
+	mountpoints := container.deviceMountpoints()
+	if len(mountpoints) == 0 {
+		return archive, nil
+	}
+	return filterArchive(archive, func(name string) bool {
+		return mountpoints[path.Clean("/"+name)]
+	}), nil
 }
 
 func (container *Container) RwChecksum() (string, error) {
-	rwData, err := Tar(container.rwPath(), Xz)
+	rwData, err := container.ExportRw()
 	if err != nil {
 		return "", err
 	}
@@ -1151,19 +1659,44 @@ func (container *Container) EnsureMounted() error {
 }
 
 func (container *Container) Mount() error {
-	image, err := container.GetImage()
-	if err != nil {
-		return err
+	if container.runtime == nil {
+		return fmt.Errorf("Can't mount unregistered container")
 	}
-	return image.Mount(container.RootfsPath(), container.rwPath())
+	return container.runtime.Mount(container)
 }
 
 func (container *Container) Changes() ([]Change, error) {
-	image, err := container.GetImage()
+	if container.runtime == nil {
+		return nil, fmt.Errorf("Can't get changes of unregistered container")
+	}
+	changes, err := container.runtime.driver.Changes(container.ID, container.initLayerID())
 	if err != nil {
 		return nil, err
 	}
-	return image.Changes(container.rwPath())
+	mountpoints := container.deviceMountpoints()
+	filtered := changes[:0]
+	for _, change := range changes {
+		if !mountpoints[change.Path] {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered, nil
+}
+
+// deviceMountpoints returns the paths the devices of the container are bind
+// mounted on. They are hidden from the changes of the container, like the
+// mountpoints of the init layer.
+func (container *Container) deviceMountpoints() map[string]bool {
+	hostConfig := container.hostConfig
+	if hostConfig == nil {
+		// Not started since the daemon was restarted
+		hostConfig, _ = container.ReadHostConfig()
+	}
+	mountpoints := make(map[string]bool)
+	for _, mapping := range hostConfig.Devices {
+		mountpoints[mapping.PathInContainer] = true
+	}
+	return mountpoints
 }
 
 func (container *Container) GetImage() (*Image, error) {
@@ -1178,7 +1711,10 @@ func (container *Container) Mounted() (bool, error) {
 }
 
 func (container *Container) Unmount() error {
-	return Unmount(container.RootfsPath())
+	if container.runtime == nil {
+		return fmt.Errorf("Can't unmount unregistered container")
+	}
+	return container.runtime.Unmount(container)
 }
 
 // ShortID returns a shorthand version of the container's id for convenience.
@@ -1193,10 +1729,28 @@ func (container *Container) logPath(name string) string {
 	return path.Join(container.root, fmt.Sprintf("%s-%s.log", container.ID, name))
 }
 
+// logSegments returns the paths of the json log of the container and of its
+// rotated segments, from the oldest to the current one.
+func (container *Container) logSegments() []string {
+	var segments []string
+	for i := 0; ; i++ {
+		pth := utils.RotatedPath(container.logPath("json"), i)
+		if _, err := os.Stat(pth); err != nil {
+			break
+		}
+		segments = append([]string{pth}, segments...)
+	}
+	return segments
+}
+
 func (container *Container) ReadLog(name string) (io.Reader, error) {
 	return os.Open(container.logPath(name))
 }
 
+func (container *Container) devicesPath() string {
+	return path.Join(container.root, "devices")
+}
+
 func (container *Container) hostConfigPath() string {
 	return path.Join(container.root, "hostconfig.json")
 }
@@ -1210,12 +1764,15 @@ func (container *Container) lxcConfigPath() string {
 }
 
 // This method must be exported to be used from the lxc template
+// It returns an empty string until the container is mounted.
 func (container *Container) RootfsPath() string {
-	return path.Join(container.root, "rootfs")
+	return container.rootfs
 }
 
-func (container *Container) rwPath() string {
-	return path.Join(container.root, "rw")
+// initLayerID returns the id of the layer holding the dockerinit mountpoints,
+// which sits between the image of the container and its rw layer.
+func (container *Container) initLayerID() string {
+	return container.ID + "-init"
 }
 
 func validateID(id string) error {
@@ -1229,15 +1786,12 @@ func validateID(id string) error {
 func (container *Container) GetSize() (int64, int64) {
 	var sizeRw, sizeRootfs int64
 
-	filepath.Walk(container.rwPath(), func(path string, fileInfo os.FileInfo, err error) error {
-		if fileInfo != nil {
-			sizeRw += fileInfo.Size()
-		}
-		return nil
-	})
+	sizeRw, err := container.runtime.driver.DiffSize(container.ID, container.initLayerID())
+	if err != nil {
+		utils.Debugf("Error getting the size of the rw layer of %s: %s", container.ID, err)
+	}
 
-	_, err := os.Stat(container.RootfsPath())
-	if err == nil {
+	if err := container.EnsureMounted(); err == nil {
 		filepath.Walk(container.RootfsPath(), func(path string, fileInfo os.FileInfo, err error) error {
 			if fileInfo != nil {
 				sizeRootfs += fileInfo.Size()
@@ -1266,5 +1820,5 @@ func (container *Container) Copy(resource string) (Archive, error) {
 		filter = []string{path.Base(basePath)}
 		basePath = path.Dir(basePath)
 	}
-	return TarFilter(basePath, Uncompressed, filter)
+	return TarFilter(basePath, &TarOptions{Includes: filter})
 }
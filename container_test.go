@@ -2,6 +2,7 @@ package docker
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -23,6 +24,7 @@ func TestIDFormat(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"/bin/sh", "-c", "echo hello world"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -393,6 +395,7 @@ func TestOutput(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"echo", "-n", "foobar"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -417,6 +420,7 @@ func TestKillDifferentUser(t *testing.T) {
 		OpenStdin: true,
 		User:      "daemon",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -471,7 +475,7 @@ func TestCreateVolume(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	c, err := runtime.Create(config)
+	c, err := runtime.Create(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -490,6 +494,7 @@ func TestKill(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"sleep", "2"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -526,6 +531,56 @@ func TestKill(t *testing.T) {
 	}
 }
 
+func TestPauseUnpause(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+	container, err := runtime.Create(&Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"sleep", "10"},
+	},
+		"",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(container)
+
+	if err := container.Pause(); err == nil {
+		t.Fatal("Pausing a stopped container should fail")
+	}
+	if err := container.Start(&HostConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give some time to lxc to spawn the process
+	container.WaitTimeout(500 * time.Millisecond)
+
+	if err := container.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !container.State.Paused || !strings.Contains(container.State.String(), "Paused") {
+		t.Errorf("Container should be paused, state is %s", container.State.String())
+	}
+	if err := container.Unpause(); err != nil {
+		t.Fatal(err)
+	}
+	if container.State.Paused {
+		t.Errorf("Container shouldn't be paused")
+	}
+
+	// A paused container can be killed
+	if err := container.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	container.Wait()
+	if container.State.Running || container.State.Paused {
+		t.Errorf("Container shouldn't be running, state is %s", container.State.String())
+	}
+}
+
 func TestExitCode(t *testing.T) {
 	runtime := mkRuntime(t)
 	defer nuke(runtime)
@@ -533,7 +588,7 @@ func TestExitCode(t *testing.T) {
 	trueContainer, err := runtime.Create(&Config{
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"/bin/true", ""},
-	})
+	}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -548,7 +603,7 @@ func TestExitCode(t *testing.T) {
 	falseContainer, err := runtime.Create(&Config{
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"/bin/false", ""},
-	})
+	}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -568,6 +623,7 @@ func TestRestart(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"echo", "-n", "foobar"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -600,6 +656,7 @@ func TestRestartStdin(t *testing.T) {
 
 		OpenStdin: true,
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -676,6 +733,7 @@ func TestUser(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"id"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -696,6 +754,7 @@ func TestUser(t *testing.T) {
 
 		User: "root",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -716,6 +775,7 @@ func TestUser(t *testing.T) {
 
 		User: "0",
 	},
+		"",
 	)
 	if err != nil || container.State.ExitCode != 0 {
 		t.Fatal(err)
@@ -736,6 +796,7 @@ func TestUser(t *testing.T) {
 
 		User: "1",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -758,6 +819,7 @@ func TestUser(t *testing.T) {
 
 		User: "daemon",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -778,6 +840,7 @@ func TestUser(t *testing.T) {
 
 		User: "unknownuser",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -797,6 +860,7 @@ func TestMultipleContainers(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"sleep", "2"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -807,6 +871,7 @@ func TestMultipleContainers(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"sleep", "2"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -853,6 +918,7 @@ func TestStdin(t *testing.T) {
 
 		OpenStdin: true,
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -898,6 +964,7 @@ func TestTty(t *testing.T) {
 
 		OpenStdin: true,
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -941,6 +1008,7 @@ func TestEnv(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"env"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -992,6 +1060,7 @@ func TestEntrypoint(t *testing.T) {
 			Entrypoint: []string{"/bin/echo"},
 			Cmd:        []string{"-n", "foobar"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1014,6 +1083,7 @@ func TestEntrypointNoCmd(t *testing.T) {
 			Image:      GetTestImage(runtime).ID,
 			Entrypoint: []string{"/bin/echo", "foobar"},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1068,6 +1138,7 @@ func TestLXCConfig(t *testing.T) {
 		Memory:    int64(mem),
 		CpuShares: int64(cpu),
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1090,6 +1161,7 @@ func TestCustomLxcConfig(t *testing.T) {
 
 		Hostname: "foobar",
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1119,6 +1191,7 @@ func BenchmarkRunSequencial(b *testing.B) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{"echo", "-n", "foo"},
 		},
+			"",
 		)
 		if err != nil {
 			b.Fatal(err)
@@ -1151,6 +1224,7 @@ func BenchmarkRunParallel(b *testing.B) {
 				Image: GetTestImage(runtime).ID,
 				Cmd:   []string{"echo", "-n", "foo"},
 			},
+				"",
 			)
 			if err != nil {
 				complete <- err
@@ -1303,6 +1377,7 @@ func TestVolumesFromReadonlyMount(t *testing.T) {
 			Cmd:     []string{"/bin/echo", "-n", "foobar"},
 			Volumes: map[string]struct{}{"/test": {}},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1322,6 +1397,7 @@ func TestVolumesFromReadonlyMount(t *testing.T) {
 			Cmd:         []string{"/bin/echo", "-n", "foobar"},
 			VolumesFrom: container.ID,
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1357,6 +1433,7 @@ func TestRestartWithVolumes(t *testing.T) {
 		Cmd:     []string{"echo", "-n", "foobar"},
 		Volumes: map[string]struct{}{"/test": {}},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1400,6 +1477,7 @@ func TestVolumesFromWithVolumes(t *testing.T) {
 		Cmd:     []string{"sh", "-c", "echo -n bar > /test/foo"},
 		Volumes: map[string]struct{}{"/test": {}},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1429,6 +1507,7 @@ func TestVolumesFromWithVolumes(t *testing.T) {
 			VolumesFrom: container.ID,
 			Volumes:     map[string]struct{}{"/test": {}},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1463,7 +1542,7 @@ func TestOnlyLoopbackExistsWhenUsingDisableNetworkOption(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	c, err := runtime.Create(config)
+	c, err := runtime.Create(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1534,6 +1613,7 @@ func TestMultipleVolumesFrom(t *testing.T) {
 		Cmd:     []string{"sh", "-c", "echo -n bar > /test/foo"},
 		Volumes: map[string]struct{}{"/test": {}},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1562,6 +1642,7 @@ func TestMultipleVolumesFrom(t *testing.T) {
 			Cmd:     []string{"sh", "-c", "echo -n bar > /other/foo"},
 			Volumes: map[string]struct{}{"/other": {}},
 		},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -1582,7 +1663,7 @@ func TestMultipleVolumesFrom(t *testing.T) {
 			Image:       GetTestImage(runtime).ID,
 			Cmd:         []string{"/bin/echo", "-n", "foobar"},
 			VolumesFrom: strings.Join([]string{container.ID, container2.ID}, ","),
-		})
+		}, "")
 
 	if err != nil {
 		t.Fatal(err)
@@ -1601,3 +1682,92 @@ func TestMultipleVolumesFrom(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestParseRestartPolicy(t *testing.T) {
+	valid := map[string]RestartPolicy{
+		"":              {Name: ""},
+		"no":            {Name: "no"},
+		"always":        {Name: "always"},
+		"on-failure":    {Name: "on-failure"},
+		"on-failure:42": {Name: "on-failure", MaximumRetryCount: 42},
+	}
+	for value, expected := range valid {
+		policy, err := ParseRestartPolicy(value)
+		if err != nil {
+			t.Fatalf("%s: %s", value, err)
+		}
+		if policy != expected {
+			t.Fatalf("%s: expected %v, got %v", value, expected, policy)
+		}
+	}
+	for _, value := range []string{"sometimes", "always:3", "on-failure:-1", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(value); err == nil {
+			t.Fatalf("%s should not be a valid restart policy", value)
+		}
+	}
+}
+
+func TestDroppedCapabilities(t *testing.T) {
+	dropped, err := droppedCapabilities(false, []string{"NET_ADMIN", "cap_mknod"}, []string{"chown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := " " + strings.Join(dropped, " ") + " "
+	if strings.Contains(joined, " mknod ") || strings.Contains(joined, " net_admin ") {
+		t.Fatalf("Added capabilities should be kept, got %v", dropped)
+	}
+	if !strings.Contains(joined, " chown ") || !strings.Contains(joined, " sys_admin ") {
+		t.Fatalf("Expected chown and the default capabilities to be dropped, got %v", dropped)
+	}
+
+	if dropped, err := droppedCapabilities(true, nil, nil); err != nil || len(dropped) != 0 {
+		t.Fatalf("Privileged containers should keep all the capabilities, got %v (%v)", dropped, err)
+	}
+	if dropped, err := droppedCapabilities(false, []string{"ALL"}, []string{"SYS_ADMIN"}); err != nil || len(dropped) != 1 || dropped[0] != "sys_admin" {
+		t.Fatalf("Expected only sys_admin to be dropped, got %v (%v)", dropped, err)
+	}
+	if dropped, err := droppedCapabilities(false, []string{"KILL"}, []string{"ALL"}); err != nil || len(dropped) != len(linuxCapabilities)-1 {
+		t.Fatalf("Expected all the capabilities but kill to be dropped, got %v (%v)", dropped, err)
+	}
+
+	if _, err := droppedCapabilities(false, []string{"NOT_A_CAP"}, nil); err == nil {
+		t.Fatal("Unknown capabilities should fail")
+	}
+	if _, err := droppedCapabilities(false, []string{"NET_ADMIN"}, []string{"net_admin"}); err == nil {
+		t.Fatal("Adding and dropping the same capability should fail")
+	}
+}
+
+func TestLxcConfigReadonlyTmpfs(t *testing.T) {
+	container := &Container{
+		ID:     "readonly",
+		Config: &Config{Hostname: "readonly", NetworkDisabled: true},
+		rootfs: "/var/lib/docker/rootfs",
+		hostConfig: &HostConfig{
+			ReadonlyRootfs: true,
+			Tmpfs:          map[string]string{"/run": "size=64m", "/tmp": ""},
+		},
+	}
+	output := &bytes.Buffer{}
+	if err := LxcTemplateCompiled.Execute(output, container); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"lxc.mount.entry = /var/lib/docker/rootfs /var/lib/docker/rootfs none bind,ro 0 0",
+		"lxc.mount.entry = tmpfs /var/lib/docker/rootfs/run tmpfs nosuid,nodev,noexec,size=64m 0 0",
+		"lxc.mount.entry = tmpfs /var/lib/docker/rootfs/tmp tmpfs nosuid,nodev,noexec 0 0",
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Fatalf("Expected %q in the lxc config:\n%s", line, output)
+		}
+	}
+
+	container.hostConfig = nil
+	output.Reset()
+	if err := LxcTemplateCompiled.Execute(output, container); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "rootfs /var/lib/docker/rootfs none") || strings.Contains(output.String(), "tmpfs /var") {
+		t.Fatalf("Expected a writable root filesystem without tmpfs:\n%s", output)
+	}
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DeviceMapping exposes the device PathOnHost in a container at
+// PathInContainer. CgroupPermissions is a combination of r (read), w
+// (write) and m (mknod).
+type DeviceMapping struct {
+	PathOnHost        string
+	PathInContainer   string
+	CgroupPermissions string
+}
+
+// parseDevice parses a -device argument of the form
+// /dev/host[:/dev/container[:permissions]]. The device keeps its path and
+// gets all the permissions by default.
+func parseDevice(device string) (DeviceMapping, error) {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 {
+		return DeviceMapping{}, fmt.Errorf("Invalid device format: %s (expected /dev/host[:/dev/container[:rwm]])", device)
+	}
+	mapping := DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		mapping.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		mapping.CgroupPermissions = parts[2]
+	}
+	if err := mapping.validate(); err != nil {
+		return DeviceMapping{}, err
+	}
+	return mapping, nil
+}
+
+func (mapping DeviceMapping) validate() error {
+	if !path.IsAbs(mapping.PathOnHost) || !path.IsAbs(mapping.PathInContainer) {
+		return fmt.Errorf("Invalid device %s:%s: paths must be absolute", mapping.PathOnHost, mapping.PathInContainer)
+	}
+	for _, p := range []string{mapping.PathOnHost, mapping.PathInContainer} {
+		// Cleaning an absolute path removes any ..
+		if path.Clean(p) != p {
+			return fmt.Errorf("Invalid device %s:%s: paths can't contain .., . or empty elements", mapping.PathOnHost, mapping.PathInContainer)
+		}
+	}
+	perms := mapping.CgroupPermissions
+	if perms == "" {
+		return fmt.Errorf("Invalid device permissions for %s: at least one of r, w and m is required", mapping.PathOnHost)
+	}
+	for i, c := range perms {
+		if !strings.ContainsRune("rwm", c) || strings.ContainsRune(perms[i+1:], c) {
+			return fmt.Errorf("Invalid device permissions for %s: %s (expected a combination of r, w and m)", mapping.PathOnHost, perms)
+		}
+	}
+	return nil
+}
+
+// device is a device node of the host exposed in a container.
+type device struct {
+	DeviceMapping
+	// "c" for a character device, "b" for a block device
+	Type  string
+	Major uint64
+	Minor uint64
+	mode  uint32
+	rdev  uint64
+	uid   int
+	gid   int
+	// Node of the device, kept out of the rootfs and bind mounted in it
+	NodePath string
+}
+
+// resolveDevice looks up the type and numbers of the device of the host
+// described by mapping.
+func resolveDevice(mapping DeviceMapping) (*device, error) {
+	if err := mapping.validate(); err != nil {
+		return nil, err
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(mapping.PathOnHost, &stat); err != nil {
+		return nil, fmt.Errorf("Unable to find device %s: %s", mapping.PathOnHost, err)
+	}
+	d := &device{
+		DeviceMapping: mapping,
+		mode:          stat.Mode,
+		rdev:          uint64(stat.Rdev),
+		uid:           int(stat.Uid),
+		gid:           int(stat.Gid),
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		d.Type = "c"
+	case syscall.S_IFBLK:
+		d.Type = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device", mapping.PathOnHost)
+	}
+	// Same encoding as the major() and minor() macros of glibc
+	d.Major = (d.rdev>>8)&0xfff | (d.rdev>>32)&^0xfff
+	d.Minor = d.rdev&0xff | (d.rdev>>12)&^0xff
+	return d, nil
+}
+
+// createNode creates the device node at nodePath, with the mode and owner of
+// the device of the host. An existing file is replaced.
+func (d *device) createNode(nodePath string) error {
+	if err := os.Remove(nodePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syscall.Mknod(nodePath, d.mode, int(d.rdev)); err != nil {
+		return fmt.Errorf("Unable to create device %s: %s", d.PathInContainer, err)
+	}
+	// mknod is subject to the umask
+	if err := os.Chmod(nodePath, os.FileMode(d.mode&0777)); err != nil {
+		return err
+	}
+	return os.Chown(nodePath, d.uid, d.gid)
+}
+
+// createMountpoint creates an empty file in the root filesystem rootfs for
+// the node to be bind mounted on, unless the image already has one. The
+// symlinks of the image are resolved inside of rootfs.
+func (d *device) createMountpoint(rootfs string) error {
+	mountpoint, err := resolveInDir(rootfs, d.PathInContainer)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(mountpoint); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mountpoint), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(mountpoint, os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	valid := map[string]DeviceMapping{
+		"/dev/fuse":                    {"/dev/fuse", "/dev/fuse", "rwm"},
+		"/dev/ttyUSB0:/dev/ttyS0":      {"/dev/ttyUSB0", "/dev/ttyS0", "rwm"},
+		"/dev/loop0:/dev/loop0:r":      {"/dev/loop0", "/dev/loop0", "r"},
+		"/dev/sdb::rw":                 {"/dev/sdb", "/dev/sdb", "rw"},
+		"/dev/snd/timer:/dev/timer:mr": {"/dev/snd/timer", "/dev/timer", "mr"},
+	}
+	for value, expected := range valid {
+		mapping, err := parseDevice(value)
+		if err != nil {
+			t.Fatalf("%s: %s", value, err)
+		}
+		if mapping != expected {
+			t.Fatalf("%s: expected %v, got %v", value, expected, mapping)
+		}
+	}
+	for _, value := range []string{"fuse", "/dev/fuse:fuse", "/dev/fuse:/dev/fuse:", "/dev/fuse:/dev/fuse:rx", "/dev/fuse:/dev/fuse:rr", "/dev/a:/dev/b:r:w", "/dev/null:/../../etc/shadow", "/dev/null:/dev/./null", "/dev/../etc/shadow"} {
+		if _, err := parseDevice(value); err == nil {
+			t.Fatalf("%s should not be a valid device", value)
+		}
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	d, err := resolveDevice(DeviceMapping{"/dev/null", "/dev/mynull", "rw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != "c" || d.Major != 1 || d.Minor != 3 {
+		t.Fatalf("Expected /dev/null to be c 1:3, got %s %d:%d", d.Type, d.Major, d.Minor)
+	}
+	if _, err := resolveDevice(DeviceMapping{"/etc/passwd", "/etc/passwd", "rwm"}); err == nil {
+		t.Fatal("Regular files should not be valid devices")
+	}
+}
+
+func TestCreateNode(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Creating device nodes requires root")
+	}
+	dir, err := ioutil.TempDir("", "docker-test-devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := resolveDevice(DeviceMapping{"/dev/null", "/dev/mynull", "rw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodePath := path.Join(dir, "0")
+	if err := d.createNode(nodePath); err != nil {
+		t.Fatal(err)
+	}
+	node, err := resolveDevice(DeviceMapping{nodePath, "/dev/mynull", "rw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Type != d.Type || node.rdev != d.rdev {
+		t.Fatalf("Expected the node to be %s %d:%d, got %s %d:%d", d.Type, d.Major, d.Minor, node.Type, node.Major, node.Minor)
+	}
+}
+
+func TestCreateMountpointInRootfs(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "docker-test-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	outside, err := ioutil.TempDir("", "docker-test-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	// An image can make /dev a symlink to anywhere
+	if err := os.Symlink(outside, path.Join(rootfs, "dev")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := resolveDevice(DeviceMapping{"/dev/null", "/dev/mynull", "rw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.createMountpoint(rootfs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path.Join(outside, "mynull")); err == nil {
+		t.Fatal("The mountpoint should not be created outside of the rootfs")
+	}
+	st, err := os.Lstat(path.Join(rootfs, outside, "mynull"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Mode().IsRegular() {
+		t.Fatalf("The mountpoint should be a regular file, got %s", st.Mode())
+	}
+}
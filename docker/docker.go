@@ -35,6 +35,11 @@ func main() {
 	flGraphPath := flag.String("g", "/var/lib/docker", "Path to graph storage base dir.")
 	flEnableCors := flag.Bool("api-enable-cors", false, "Enable CORS requests in the remote api.")
 	flDns := flag.String("dns", "", "Set custom dns servers")
+	flGraphDriver := flag.String("s", "", "Force the docker runtime to use a specific storage driver (aufs, btrfs, vfs)")
+	flLogDriver := flag.String("log-driver", "json-file", "Default log driver for containers (json-file, syslog, none)")
+	flLogSyslogAddress := flag.String("log-syslog-address", "", "Default address of the syslog daemon for the syslog log driver (unix:///path or udp://host:port)")
+	flLogMaxSize := flag.String("log-max-size", "", "Default size at which container logs are rotated (eg. 10m), unlimited by default")
+	flLogMaxFiles := flag.Int("log-max-files", 1, "Default maximum number of log files kept per container when rotating")
 	flHosts := docker.ListOpts{fmt.Sprintf("unix://%s", docker.DEFAULTUNIXSOCKET)}
 	flag.Var(&flHosts, "H", "tcp://host:port to bind/connect to or unix://path/to/socket to use")
 	flag.Parse()
@@ -57,6 +62,23 @@ func main() {
 	if *flDebug {
 		os.Setenv("DEBUG", "1")
 	}
+	docker.GraphDriverName = *flGraphDriver
+	if *flLogMaxSize != "" {
+		size, err := utils.RAMInBytes(*flLogMaxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		docker.DefaultLogConfig.MaxSize = size
+	}
+	if *flLogMaxFiles < 1 {
+		log.Fatalf("Invalid maximum number of log files: %d", *flLogMaxFiles)
+	}
+	if err := docker.ValidateLogDriver(*flLogDriver); err != nil {
+		log.Fatal(err)
+	}
+	docker.DefaultLogConfig.MaxFiles = *flLogMaxFiles
+	docker.DefaultLogConfig.Type = *flLogDriver
+	docker.DefaultLogConfig.SyslogAddress = *flLogSyslogAddress
 	docker.GITCOMMIT = GITCOMMIT
 	docker.VERSION = VERSION
 	if *flDaemon {
@@ -121,11 +143,17 @@ func daemon(pidfile string, flGraphPath string, protoAddrs []string, autoRestart
 	}
 	defer removePidFile(pidfile)
 
+	var server *docker.Server
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, os.Signal(syscall.SIGTERM))
 	go func() {
 		sig := <-c
 		log.Printf("Received signal '%v', exiting\n", sig)
+		if server != nil {
+			if err := server.Close(); err != nil {
+				log.Printf("Error cleaning up the runtime: %s", err)
+			}
+		}
 		removePidFile(pidfile)
 		os.Exit(0)
 	}()
@@ -0,0 +1,261 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/term"
+	"github.com/dotcloud/docker/utils"
+	"github.com/kr/pty"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How long the execs which are not running are kept, eg. for their exit code
+const execTTL = 5 * time.Minute
+
+// ExecConfig describes a process to start in a running container.
+type ExecConfig struct {
+	Cmd       []string
+	Tty       bool
+	OpenStdin bool
+}
+
+// Exec is a process started by docker exec in the namespaces of a running
+// container. It is created first, then started with its streams attached
+// to the client, and keeps its exit code once it returned.
+type Exec struct {
+	sync.Mutex
+	ID        string
+	Config    ExecConfig
+	Running   bool
+	ExitCode  int
+	container *Container
+	ptyMaster *os.File
+	// Last size requested by Resize, applied to the tty once it is opened
+	winsize *term.Winsize
+	// Closed once the process can't be started anymore
+	done chan struct{}
+	// When the exec was created, or its process exited
+	updated time.Time
+}
+
+func newExec(container *Container, config *ExecConfig) *Exec {
+	return &Exec{
+		ID:        GenerateID(),
+		Config:    *config,
+		container: container,
+		done:      make(chan struct{}),
+		updated:   time.Now(),
+	}
+}
+
+// cmd returns the command running the process in the container with
+// lxc-attach. The process is set up by dockerinit, like the main process of
+// the container, but without networking setup.
+func (e *Exec) cmd() *exec.Cmd {
+	container := e.container
+	params := []string{
+		"-n", container.ID,
+		"--",
+		"/.dockerinit",
+	}
+	if container.Config.User != "" {
+		params = append(params, "-u", container.Config.User)
+	}
+	for _, ulimit := range container.Config.Ulimits {
+		params = append(params, "-ulimit", ulimit.String())
+	}
+	if e.Config.Tty {
+		params = append(params, "-e", "TERM=xterm")
+	}
+	for _, elem := range container.env() {
+		params = append(params, "-e", elem)
+	}
+	if container.Config.WorkingDir != "" {
+		params = append(params, "-w", path.Clean(container.Config.WorkingDir))
+	}
+	params = append(params, "--")
+	params = append(params, e.Config.Cmd...)
+	return exec.Command("lxc-attach", params...)
+}
+
+// Start runs the process, copying stdin to it if it was created with
+// OpenStdin, and its output to stdout. Without a tty, stderr is written
+// to stderr. It returns once the process exited.
+func (e *Exec) Start(stdin io.Reader, stdout, stderr io.Writer) error {
+	e.Lock()
+	select {
+	case <-e.done:
+		e.Unlock()
+		return fmt.Errorf("Exec %s has already been started", utils.TruncateID(e.ID))
+	default:
+	}
+	close(e.done)
+	if !e.container.State.Running {
+		e.Unlock()
+		return fmt.Errorf("Container %s is not running", e.container.ShortID())
+	}
+	if e.container.State.Paused {
+		e.Unlock()
+		return fmt.Errorf("Container %s is paused, unpause it first", e.container.ShortID())
+	}
+
+	cmd := e.cmd()
+	var output chan error
+	if e.Config.Tty {
+		ptyMaster, ptySlave, err := e.openPty()
+		if err != nil {
+			e.Unlock()
+			return err
+		}
+		defer ptyMaster.Close()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = ptySlave, ptySlave, ptySlave
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setctty: true, Setsid: true}
+		output = utils.Go(func() error {
+			// Reading the master fails once the process exited
+			io.Copy(stdout, ptyMaster)
+			return nil
+		})
+		if e.Config.OpenStdin && stdin != nil {
+			go io.Copy(ptyMaster, stdin)
+		}
+		defer ptySlave.Close()
+	} else {
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if e.Config.OpenStdin && stdin != nil {
+			// Not cmd.Stdin, since Wait would wait for the end of stdin
+			pipe, err := cmd.StdinPipe()
+			if err != nil {
+				e.Unlock()
+				return err
+			}
+			go func() {
+				io.Copy(pipe, stdin)
+				pipe.Close()
+			}()
+		}
+	}
+	if err := startWithUlimits(cmd, e.container.Config.Ulimits); err != nil {
+		e.Unlock()
+		return err
+	}
+	e.Running = true
+	e.Unlock()
+	utils.Debugf("Exec %s started in %s: %v", utils.TruncateID(e.ID), e.container.ShortID(), e.Config.Cmd)
+
+	if e.Config.Tty {
+		// Only the process holds the slave now, so that reading the
+		// master ends with it
+		cmd.Stdin.(*os.File).Close()
+	}
+	err := cmd.Wait()
+	if output != nil {
+		<-output
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.Running = false
+	e.updated = time.Now()
+	e.ExitCode = -1
+	if cmd.ProcessState == nil {
+		return err
+	}
+	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	e.ExitCode = status.ExitStatus()
+	if status.Signaled() {
+		return fmt.Errorf("Exec %s was killed by signal %d", utils.TruncateID(e.ID), status.Signal())
+	}
+	if err != nil {
+		// Non 0 exit codes are reported as errors, but are given by ExitCode
+		utils.Debugf("Exec %s: %s", utils.TruncateID(e.ID), err)
+	}
+	return nil
+}
+
+// openPty opens the tty of the process, with the size last requested by
+// Resize. The exec must be locked.
+func (e *Exec) openPty() (*os.File, *os.File, error) {
+	ptyMaster, ptySlave, err := pty.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.winsize != nil {
+		if err := term.SetWinsize(ptyMaster.Fd(), e.winsize); err != nil {
+			ptyMaster.Close()
+			ptySlave.Close()
+			return nil, nil, err
+		}
+	}
+	e.ptyMaster = ptyMaster
+	return ptyMaster, ptySlave, nil
+}
+
+// Resize changes the size of the tty of the process. The client may resize
+// it before starting the process: the size is then applied once the tty is
+// opened.
+func (e *Exec) Resize(h, w int) error {
+	e.Lock()
+	defer e.Unlock()
+	if !e.Config.Tty {
+		return fmt.Errorf("Exec %s has no tty", utils.TruncateID(e.ID))
+	}
+	e.winsize = &term.Winsize{Height: uint16(h), Width: uint16(w)}
+	if e.ptyMaster == nil {
+		return nil
+	}
+	return term.SetWinsize(e.ptyMaster.Fd(), e.winsize)
+}
+
+// execStore holds the processes started by docker exec, by id.
+type execStore struct {
+	sync.Mutex
+	execs map[string]*Exec
+}
+
+func newExecStore() *execStore {
+	return &execStore{execs: make(map[string]*Exec)}
+}
+
+func (store *execStore) Add(e *Exec) {
+	store.Lock()
+	defer store.Unlock()
+	store.expire()
+	store.execs[e.ID] = e
+}
+
+// expire forgets the execs which have not been running for execTTL. The
+// store must be locked.
+func (store *execStore) expire() {
+	for id, e := range store.execs {
+		e.Lock()
+		expired := !e.Running && time.Since(e.updated) > execTTL
+		e.Unlock()
+		if expired {
+			delete(store.execs, id)
+		}
+	}
+}
+
+func (store *execStore) Get(id string) *Exec {
+	store.Lock()
+	defer store.Unlock()
+	return store.execs[id]
+}
+
+// DeleteContainer forgets the processes started in the container id, once
+// it stopped or was destroyed.
+func (store *execStore) DeleteContainer(id string) {
+	store.Lock()
+	defer store.Unlock()
+	for execID, e := range store.execs {
+		if e.container.ID == id {
+			delete(store.execs, execID)
+		}
+	}
+}
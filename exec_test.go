@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"github.com/dotcloud/docker/term"
+	"testing"
+	"time"
+)
+
+func TestExecStoreExpire(t *testing.T) {
+	store := newExecStore()
+	container := &Container{ID: GenerateID()}
+	running := newExec(container, &ExecConfig{Cmd: []string{"sleep", "1000"}})
+	running.Running = true
+	running.updated = time.Now().Add(-2 * execTTL)
+	finished := newExec(container, &ExecConfig{Cmd: []string{"true"}})
+	finished.updated = time.Now().Add(-2 * execTTL)
+	recent := newExec(container, &ExecConfig{Cmd: []string{"true"}})
+	store.Add(running)
+	store.Add(finished)
+	store.Add(recent)
+
+	store.Add(newExec(container, &ExecConfig{Cmd: []string{"true"}}))
+	if store.Get(finished.ID) != nil {
+		t.Fatal("An exec which exited long ago should have expired")
+	}
+	if store.Get(running.ID) == nil || store.Get(recent.ID) == nil {
+		t.Fatal("Running and recent execs should be kept")
+	}
+
+	store.DeleteContainer(container.ID)
+	if store.Get(running.ID) != nil || store.Get(recent.ID) != nil {
+		t.Fatal("The execs of a stopped container should be forgotten")
+	}
+}
+
+func TestExecResizeBeforeStart(t *testing.T) {
+	e := newExec(&Container{ID: GenerateID()}, &ExecConfig{Cmd: []string{"bash"}, Tty: true})
+	// The client resizes the tty before starting the process
+	if err := e.Resize(40, 120); err != nil {
+		t.Fatal(err)
+	}
+	ptyMaster, ptySlave, err := e.openPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ptyMaster.Close()
+	defer ptySlave.Close()
+	ws, err := term.GetWinsize(ptySlave.Fd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Height != 40 || ws.Width != 120 {
+		t.Fatalf("Expected a 40x120 tty, got %dx%d", ws.Height, ws.Width)
+	}
+
+	if err := newExec(e.container, &ExecConfig{Cmd: []string{"true"}}).Resize(40, 120); err == nil {
+		t.Fatal("Resizing an exec without a tty should fail")
+	}
+}
@@ -16,11 +16,13 @@ import (
 type Graph struct {
 	Root    string
 	idIndex *utils.TruncIndex
+	driver  GraphDriver
 }
 
 // NewGraph instantiates a new graph at the given root path in the filesystem.
 // `root` will be created if it doesn't exist.
-func NewGraph(root string) (*Graph, error) {
+// The filesystem layers of the images are stored by `driver`.
+func NewGraph(root string, driver GraphDriver) (*Graph, error) {
 	abspath, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
@@ -32,6 +34,7 @@ func NewGraph(root string) (*Graph, error) {
 	graph := &Graph{
 		Root:    abspath,
 		idIndex: utils.NewTruncIndex(),
+		driver:  driver,
 	}
 	if err := graph.restore(); err != nil {
 		return nil, err
@@ -80,13 +83,17 @@ func (graph *Graph) Get(name string) (*Image, error) {
 	if img.ID != id {
 		return nil, fmt.Errorf("Image stored at '%s' has wrong id '%s'", id, img.ID)
 	}
+	if !graph.driver.Exists(img.ID) {
+		return nil, fmt.Errorf("Couldn't load image %s: no filesystem layer", img.ID)
+	}
 	img.graph = graph
 	if img.Size == 0 {
-		root, err := img.root()
+		size, err := graph.driver.DiffSize(img.ID, img.Parent)
 		if err != nil {
 			return nil, err
 		}
-		if err := StoreSize(img, root); err != nil {
+		img.Size = size
+		if err := StoreSize(img, graph.imageRoot(id)); err != nil {
 			return nil, err
 		}
 	}
@@ -130,11 +137,26 @@ func (graph *Graph) Register(jsonData []byte, layerData Archive, img *Image) err
 	if err != nil {
 		return fmt.Errorf("Mktemp failed: %s", err)
 	}
-	if err := StoreImage(img, jsonData, layerData, tmp); err != nil {
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return err
+	}
+
+	// A layer left over by a previous failed registration is discarded
+	if graph.driver.Exists(img.ID) {
+		if err := graph.driver.Remove(img.ID); err != nil {
+			return fmt.Errorf("Driver %s failed to remove stale layer %s: %s", graph.driver, img.ID, err)
+		}
+	}
+	if err := graph.driver.Create(img.ID, img.Parent); err != nil {
+		return fmt.Errorf("Driver %s failed to create layer %s: %s", graph.driver, img.ID, err)
+	}
+	if err := graph.storeLayer(img, jsonData, layerData, tmp); err != nil {
+		graph.driver.Remove(img.ID)
 		return err
 	}
 	// Commit
 	if err := os.Rename(tmp, graph.imageRoot(img.ID)); err != nil {
+		graph.driver.Remove(img.ID)
 		return err
 	}
 	img.graph = graph
@@ -142,11 +164,106 @@ func (graph *Graph) Register(jsonData []byte, layerData Archive, img *Image) err
 	return nil
 }
 
+// Squash creates an image on top of `parent` whose single layer holds the
+// changes of all the layers of `img` above `parent`, and which has the config
+// of `img`. An empty parent merges all the layers of `img` into a base image.
+// The merged images are recorded in the Squashed field of the new image.
+func (graph *Graph) Squash(img *Image, parent string) (*Image, error) {
+	history, err := img.History()
+	if err != nil {
+		return nil, err
+	}
+	var squashed []SquashedImage
+	found := false
+	for _, layer := range history {
+		if layer.ID == parent {
+			found = true
+			break
+		}
+		squashed = append(squashed, SquashedImage{
+			ID:        layer.ID,
+			Created:   layer.Created,
+			Comment:   layer.Comment,
+			CreatedBy: layer.ContainerConfig.Cmd,
+		})
+		squashed = append(squashed, layer.Squashed...)
+	}
+	if parent != "" && !found {
+		return nil, fmt.Errorf("Image %s is not a parent of %s", utils.TruncateID(parent), img.ShortID())
+	}
+	if len(squashed) == 0 {
+		return nil, fmt.Errorf("No layers to squash between %s and %s", img.ShortID(), utils.TruncateID(parent))
+	}
+
+	// Compare the full filesystems, so that files added then deleted by the
+	// squashed layers are left out, and whiteouts hide the files of parent
+	layerFs, err := graph.driver.Get(img.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.driver.Put(img.ID)
+	parentFs := ""
+	if parent != "" {
+		if parentFs, err = graph.driver.Get(parent); err != nil {
+			return nil, err
+		}
+		defer graph.driver.Put(parent)
+	}
+	// The content is compared too, since the files of a layer may have the
+	// same size and mtime as the ones they replace
+	changes, err := changesDirs(layerFs, parentFs, true)
+	if err != nil {
+		return nil, err
+	}
+	layerData, err := ExportChanges(layerFs, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	squashedImg := &Image{
+		ID:      GenerateID(),
+		Parent:  parent,
+		Comment: fmt.Sprintf("Squashed %d layers of %s", len(squashed), img.ID),
+		Created: time.Now(),
+		// Not the config of a container which could be found in the build cache
+		ContainerConfig: Config{Cmd: []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) squash %d layers", len(squashed))}},
+		DockerVersion:   VERSION,
+		Author:          img.Author,
+		Config:          img.Config,
+		Architecture:    img.Architecture,
+		Squashed:        squashed,
+	}
+	if err := graph.Register(nil, layerData, squashedImg); err != nil {
+		return nil, err
+	}
+	return squashedImg, nil
+}
+
+// storeLayer unpacks the layer data of an image with the storage driver,
+// then writes its metadata into `root`.
+func (graph *Graph) storeLayer(img *Image, jsonData []byte, layerData Archive, root string) error {
+	// If layerData is not nil, unpack it into the new layer
+	if layerData != nil {
+		start := time.Now()
+		utils.Debugf("Start untar layer")
+		if err := graph.driver.ApplyDiff(img.ID, img.Parent, layerData); err != nil {
+			return err
+		}
+		utils.Debugf("Untar time: %vs\n", time.Now().Sub(start).Seconds())
+	}
+	size, err := graph.driver.DiffSize(img.ID, img.Parent)
+	if err != nil {
+		return err
+	}
+	img.Size = size
+	return StoreImage(img, jsonData, root)
+}
+
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
 //   The archive is stored on disk and will be automatically deleted as soon as has been read.
 //   If output is not nil, a human-readable progress bar will be written to it.
 //   FIXME: does this belong in Graph? How about MktempFile, let the caller use it for archives?
-func (graph *Graph) TempLayerArchive(id string, compression Compression, sf *utils.StreamFormatter, output io.Writer) (*TempArchive, error) {
+func (graph *Graph) TempLayerArchive(id string, sf *utils.StreamFormatter, output io.Writer) (*TempArchive, error) {
 	image, err := graph.Get(id)
 	if err != nil {
 		return nil, err
@@ -155,7 +272,7 @@ func (graph *Graph) TempLayerArchive(id string, compression Compression, sf *uti
 	if err != nil {
 		return nil, err
 	}
-	archive, err := image.TarLayer(compression)
+	archive, err := image.TarLayer()
 	if err != nil {
 		return nil, err
 	}
@@ -177,24 +294,13 @@ func (graph *Graph) Mktemp(id string) (string, error) {
 	return tmp.imageRoot(id), nil
 }
 
-// getDockerInitLayer returns the path of a layer containing a mountpoint suitable
+// setupInitLayer populates a directory with mountpoints suitable
 // for bind-mounting dockerinit into the container. The mountpoint is simply an
 // empty file at /.dockerinit
 //
-// This extra layer is used by all containers as the top-most ro layer. It protects
+// Each container gets such a layer between its image and its rw layer. It protects
 // the container from unwanted side-effects on the rw layer.
-func (graph *Graph) getDockerInitLayer() (string, error) {
-	tmp, err := graph.tmp()
-	if err != nil {
-		return "", err
-	}
-	initLayer := tmp.imageRoot("_dockerinit")
-	if err := os.Mkdir(initLayer, 0755); err != nil && !os.IsExist(err) {
-		// If directory already existed, keep going.
-		// For all other errors, abort.
-		return "", err
-	}
-
+func setupInitLayer(initLayer string) error {
 	for pth, typ := range map[string]string{
 		"/dev/pts":         "dir",
 		"/dev/shm":         "dir",
@@ -212,32 +318,32 @@ func (graph *Graph) getDockerInitLayer() (string, error) {
 				switch typ {
 				case "dir":
 					if err := os.MkdirAll(path.Join(initLayer, pth), 0755); err != nil {
-						return "", err
+						return err
 					}
 				case "file":
 					if err := os.MkdirAll(path.Join(initLayer, path.Dir(pth)), 0755); err != nil {
-						return "", err
+						return err
 					}
 
 					if f, err := os.OpenFile(path.Join(initLayer, pth), os.O_CREATE, 0755); err != nil {
-						return "", err
+						return err
 					} else {
 						f.Close()
 					}
 				}
 			} else {
-				return "", err
+				return err
 			}
 		}
 	}
 
 	// Layer is ready to use, if it wasn't before.
-	return initLayer, nil
+	return nil
 }
 
 func (graph *Graph) tmp() (*Graph, error) {
 	// Changed to _tmp from :tmp:, because it messed with ":" separators in aufs branch syntax...
-	return NewGraph(path.Join(graph.Root, "_tmp"))
+	return NewGraph(path.Join(graph.Root, "_tmp"), graph.driver)
 }
 
 // Check if given error is "not empty".
@@ -269,6 +375,9 @@ func (graph *Graph) Delete(name string) error {
 	if err != nil {
 		return err
 	}
+	if err := graph.driver.Remove(id); err != nil {
+		return err
+	}
 	return os.RemoveAll(tmp)
 }
 
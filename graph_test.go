@@ -8,7 +8,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -131,28 +133,285 @@ func TestMount(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tmp, err := ioutil.TempDir("", "docker-test-graph-mount-")
+	rootfs, err := graph.driver.Get(image.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(tmp)
-	rootfs := path.Join(tmp, "rootfs")
-	if err := os.MkdirAll(rootfs, 0700); err != nil {
+	defer graph.driver.Put(image.ID)
+	if _, err := os.Stat(path.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test that the changes of a child layer can be exported and applied on another layer
+func TestDriverDiff(t *testing.T) {
+	graph := tempGraph(t)
+	defer os.RemoveAll(graph.Root)
+	driver := graph.driver
+	base := createTestImage(graph, t)
+
+	if err := driver.Create("child", base.ID); err != nil {
+		t.Fatal(err)
+	}
+	rootfs, err := driver.Get("child")
+	if err != nil {
 		t.Fatal(err)
 	}
-	rw := path.Join(tmp, "rw")
-	if err := os.MkdirAll(rw, 0700); err != nil {
+	if err := os.Remove(path.Join(rootfs, "etc", "passwd")); err != nil {
 		t.Fatal(err)
 	}
-	if err := image.Mount(rootfs, rw); err != nil {
+	writeFile(path.Join(rootfs, "etc", "hosts"), "127.0.0.1 localhost\n", t)
+	driver.Put("child")
+
+	changes, err := driver.Changes("child", base.ID)
+	if err != nil {
 		t.Fatal(err)
 	}
-	// FIXME: test for mount contents
-	defer func() {
-		if err := Unmount(rootfs); err != nil {
-			t.Error(err)
+	expected := map[string]ChangeType{
+		"/etc/passwd": ChangeDelete,
+		"/etc/hosts":  ChangeAdd,
+	}
+	for _, change := range changes {
+		if kind, exists := expected[change.Path]; !exists || kind != change.Kind {
+			t.Errorf("Unexpected change: %s", change.String())
 		}
-	}()
+		delete(expected, change.Path)
+	}
+	if len(expected) != 0 {
+		t.Fatalf("Missing changes: %v", expected)
+	}
+
+	diff, err := driver.Diff("child", base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create("copy", base.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.ApplyDiff("copy", base.ID, diff); err != nil {
+		t.Fatal(err)
+	}
+	copyfs, err := driver.Get("copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put("copy")
+	if _, err := os.Stat(path.Join(copyfs, "etc", "passwd")); !os.IsNotExist(err) {
+		t.Fatalf("/etc/passwd should have been deleted (%v)", err)
+	}
+	if _, err := os.Stat(path.Join(copyfs, "etc", "hosts")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(copyfs, "etc", ".wh.passwd")); !os.IsNotExist(err) {
+		t.Fatalf("Whiteout files should not be left in the layer (%v)", err)
+	}
+}
+
+// layerTar returns a layer archive of empty files
+func layerTar(t *testing.T, names ...string) Archive {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestSquash(t *testing.T) {
+	graph := tempGraph(t)
+	defer os.RemoveAll(graph.Root)
+	base := createTestImage(graph, t)
+
+	first := &Image{ID: GenerateID(), Parent: base.ID, Created: time.Now()}
+	if err := graph.Register(nil, layerTar(t, "etc/hosts", "tmp/scratch"), first); err != nil {
+		t.Fatal(err)
+	}
+	second := &Image{ID: GenerateID(), Parent: first.ID, Created: time.Now(), Config: &Config{Cmd: []string{"true"}}}
+	if err := graph.Register(nil, layerTar(t, "etc/.wh.passwd", "tmp/.wh.scratch"), second); err != nil {
+		t.Fatal(err)
+	}
+
+	squashed, err := graph.Squash(second, base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if squashed.Parent != base.ID || len(squashed.Squashed) != 2 || squashed.Squashed[0].ID != second.ID || squashed.Squashed[1].ID != first.ID {
+		t.Fatalf("Unexpected squashed image: %#v", squashed)
+	}
+	if squashed.Config == nil || len(squashed.Config.Cmd) != 1 || squashed.Config.Cmd[0] != "true" {
+		t.Fatalf("The config of the image should be kept: %#v", squashed.Config)
+	}
+	changes, err := graph.driver.Changes(squashed.ID, base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ChangeType{
+		"/etc/passwd": ChangeDelete,
+		"/etc/hosts":  ChangeAdd,
+		"/tmp":        ChangeAdd,
+	}
+	for _, change := range changes {
+		if kind, exists := expected[change.Path]; !exists || kind != change.Kind {
+			t.Errorf("Unexpected change: %s", change.String())
+		}
+		delete(expected, change.Path)
+	}
+	if len(expected) != 0 {
+		t.Fatalf("Missing changes: %v", expected)
+	}
+
+	// Squashing everything gives a base image
+	squashed, err = graph.Squash(second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if squashed.Parent != "" || len(squashed.Squashed) != 3 {
+		t.Fatalf("Unexpected squashed image: %#v", squashed)
+	}
+
+	if _, err := graph.Squash(first, second.ID); err == nil {
+		t.Fatalf("Squashing from an image which is not a parent should fail")
+	}
+	if _, err := graph.Squash(first, first.ID); err == nil {
+		t.Fatalf("Squashing no layers should fail")
+	}
+}
+
+func TestSquashOwnershipAndContent(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing the owner of files requires root")
+	}
+	graph := tempGraph(t)
+	defer os.RemoveAll(graph.Root)
+
+	// Files with the same mode, size and mtime in both layers
+	mtime := time.Unix(1400000000, 0)
+	layer := func(uid int, content string) Archive {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for _, hdr := range []*tar.Header{
+			{Name: "etc/hosts", Mode: 0644, Uid: uid, ModTime: mtime},
+			{Name: "etc/motd", Mode: 0644, Size: int64(len(content)), ModTime: mtime},
+		} {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Size > 0 {
+				if _, err := tw.Write([]byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	base := &Image{ID: GenerateID(), Created: time.Now()}
+	if err := graph.Register(nil, layer(0, "hello"), base); err != nil {
+		t.Fatal(err)
+	}
+	chowned := &Image{ID: GenerateID(), Parent: base.ID, Created: time.Now()}
+	if err := graph.Register(nil, layer(1000, "world"), chowned); err != nil {
+		t.Fatal(err)
+	}
+
+	squashed, err := graph.Squash(chowned, base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := graph.driver.Get(squashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.driver.Put(squashed.ID)
+	fi, err := os.Lstat(path.Join(fs, "etc", "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; uid != 1000 {
+		t.Fatalf("The squashed image should keep the owner of /etc/hosts, expected 1000, got %d", uid)
+	}
+	content, err := ioutil.ReadFile(path.Join(fs, "etc", "motd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "world" {
+		t.Fatalf("The squashed image should keep the content of /etc/motd, expected world, got %s", content)
+	}
+}
+
+// Test the btrfs driver on a loopback btrfs filesystem
+func TestBtrfsDriver(t *testing.T) {
+	for _, tool := range []string{"mkfs.btrfs", "btrfs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip("btrfs tools are not installed")
+		}
+	}
+	tmp, err := ioutil.TempDir("", "docker-btrfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Create a btrfs filesystem in a sparse image file, and loop-mount it
+	image := path.Join(tmp, "btrfs.img")
+	if f, err := os.Create(image); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Truncate(1 << 30)
+		f.Close()
+	}
+	if output, err := exec.Command("mkfs.btrfs", image).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.btrfs failed: %s (%s)", err, output)
+	}
+	mnt := path.Join(tmp, "mnt")
+	if err := os.Mkdir(mnt, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command("mount", "-o", "loop", image, mnt).CombinedOutput(); err != nil {
+		t.Skipf("Unable to mount the btrfs image: %s (%s)", err, output)
+	}
+	defer exec.Command("umount", mnt).Run()
+
+	driver, err := NewGraphDriver("btrfs", mnt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(path.Join(mnt, "graph"), driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := createTestImage(graph, t)
+	if err := driver.Create("child", base.ID); err != nil {
+		t.Fatal(err)
+	}
+	rootfs, err := driver.Get("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatalf("The snapshot should contain the files of its parent: %s", err)
+	}
+	writeFile(path.Join(rootfs, "etc", "hosts"), "127.0.0.1 localhost\n", t)
+	changes, err := driver.Changes("child", base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "/etc/hosts" || changes[0].Kind != ChangeAdd {
+		t.Fatalf("Unexpected changes: %v", changes)
+	}
+	if err := driver.Remove("child"); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Delete(base.ID); err != nil {
+		t.Fatal(err)
+	}
 }
 
 // Test that an image can be deleted by its shorthand prefix
@@ -294,7 +553,11 @@ func tempGraph(t *testing.T) *Graph {
 	if err != nil {
 		t.Fatal(err)
 	}
-	graph, err := NewGraph(tmp)
+	driver, err := NewGraphDriver("vfs", tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(tmp, driver)
 	if err != nil {
 		t.Fatal(err)
 	}
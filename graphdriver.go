@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A GraphDriver manages the filesystem layers of images and containers.
+// Each layer is identified by an id and may be stacked on top of a parent layer.
+type GraphDriver interface {
+	// String returns the name of the driver, as given to the -s daemon flag.
+	String() string
+
+	// Create prepares a new, empty layer on top of `parent`.
+	// An empty parent creates a base layer.
+	Create(id, parent string) error
+	// Remove deletes the layer and everything the driver stored for it.
+	Remove(id string) error
+	// Get returns the path of a directory holding the full filesystem of the layer,
+	// mounting it if needed.
+	Get(id string) (dir string, err error)
+	// Put releases the directory returned by Get.
+	Put(id string)
+	// Exists returns true if a layer with the given id has been created.
+	Exists(id string) bool
+
+	// Diff returns a tar archive of the changes between the layer and its parent.
+	Diff(id, parent string) (Archive, error)
+	// Changes returns the list of files added, modified or deleted in the layer.
+	Changes(id, parent string) ([]Change, error)
+	// ApplyDiff unpacks an archive produced by Diff into the layer.
+	ApplyDiff(id, parent string, diff Archive) error
+	// DiffSize returns the size in bytes of the files changed in the layer.
+	DiffSize(id, parent string) (int64, error)
+
+	// Cleanup releases any resource held by the driver before the daemon exits.
+	Cleanup() error
+}
+
+type graphDriverInitFunc func(root string) (GraphDriver, error)
+
+var (
+	// GraphDriverName forces the runtime to use a specific storage driver.
+	// When empty, the first driver supported by the host is used.
+	GraphDriverName string
+
+	graphDrivers = make(map[string]graphDriverInitFunc)
+
+	// Drivers are tried in this order when none is requested explicitly.
+	graphDriverPriority = []string{
+		"aufs",
+		"btrfs",
+		"vfs",
+	}
+)
+
+func registerGraphDriver(name string, initFunc graphDriverInitFunc) {
+	if _, exists := graphDrivers[name]; exists {
+		panic(fmt.Sprintf("Graph driver %s registered twice", name))
+	}
+	graphDrivers[name] = initFunc
+}
+
+// NewGraphDriver returns the storage driver called `name`, storing its data under `root`.
+// If `name` is empty, the first driver of graphDriverPriority which can be
+// initialized on this host is returned.
+func NewGraphDriver(name, root string) (GraphDriver, error) {
+	if name != "" {
+		initFunc, exists := graphDrivers[name]
+		if !exists {
+			return nil, fmt.Errorf("No such storage driver: %s", name)
+		}
+		return initFunc(path.Join(root, name))
+	}
+	for _, name := range graphDriverPriority {
+		initFunc, exists := graphDrivers[name]
+		if !exists {
+			continue
+		}
+		driver, err := initFunc(path.Join(root, name))
+		if err != nil {
+			utils.Debugf("Storage driver %s not supported: %s", name, err)
+			continue
+		}
+		return driver, nil
+	}
+	return nil, fmt.Errorf("No supported storage driver found")
+}
+
+// The following helpers implement Diff, Changes, ApplyDiff and DiffSize
+// for drivers which have no native notion of a diff (eg. vfs). They
+// compare the full filesystems of a layer and its parent.
+
+func naiveChanges(driver GraphDriver, id, parent string) ([]Change, error) {
+	layerFs, err := driver.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer driver.Put(id)
+
+	parentFs := ""
+	if parent != "" {
+		parentFs, err = driver.Get(parent)
+		if err != nil {
+			return nil, err
+		}
+		defer driver.Put(parent)
+	}
+	return ChangesDirs(layerFs, parentFs)
+}
+
+func naiveDiff(driver GraphDriver, id, parent string) (Archive, error) {
+	changes, err := naiveChanges(driver, id, parent)
+	if err != nil {
+		return nil, err
+	}
+	layerFs, err := driver.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ExportChanges(layerFs, changes)
+	if err != nil {
+		driver.Put(id)
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		_, err := io.Copy(w, archive)
+		driver.Put(id)
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+func naiveApplyDiff(driver GraphDriver, id string, diff Archive) error {
+	layerFs, err := driver.Get(id)
+	if err != nil {
+		return err
+	}
+	defer driver.Put(id)
+	return ApplyLayer(layerFs, diff)
+}
+
+func naiveDiffSize(driver GraphDriver, id, parent string) (int64, error) {
+	changes, err := naiveChanges(driver, id, parent)
+	if err != nil {
+		return 0, err
+	}
+	layerFs, err := driver.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	defer driver.Put(id)
+	return ChangesSize(layerFs, changes), nil
+}
+
+// ExportChanges produces a tar archive of the files listed in `changes`, read from `dir`.
+// Deleted files are recorded as AUFS-style whiteouts, so that the archive can be
+// applied with ApplyLayer or mounted as an AUFS branch.
+func ExportChanges(dir string, changes []Change) (Archive, error) {
+	r, w := io.Pipe()
+	go func() {
+		ta := newTarAppender(w, &TarOptions{})
+		for _, change := range changes {
+			if err := exportChange(ta, dir, change); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		if err := ta.tw.Close(); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.Close()
+	}()
+	return r, nil
+}
+
+func exportChange(ta *tarAppender, dir string, change Change) error {
+	name := strings.TrimPrefix(change.Path, "/")
+	if change.Kind == ChangeDelete {
+		whiteout := filepath.Join(filepath.Dir(name), ".wh."+filepath.Base(name))
+		return ta.tw.WriteHeader(&tar.Header{
+			Name:     whiteout,
+			Mode:     0444,
+			Typeflag: tar.TypeReg,
+		})
+	}
+	return ta.addTarFile(filepath.Join(dir, change.Path), name)
+}
+
+// ApplyLayer unpacks a layer archive into `dest`, then honors the AUFS whiteouts
+// it contained by removing the files they hide.
+func ApplyLayer(dest string, layer Archive) error {
+	if err := Untar(layer, dest, nil); err != nil {
+		return err
+	}
+	return filepath.Walk(dest, func(pth string, f os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The file was hidden by a whiteout we already processed
+				return nil
+			}
+			return err
+		}
+		base := filepath.Base(pth)
+		if !strings.HasPrefix(base, ".wh.") {
+			return nil
+		}
+		// AUFS metadata (eg. .wh..wh.aufs) is simply dropped
+		if !strings.HasPrefix(base, ".wh..wh.") {
+			if err := os.RemoveAll(filepath.Join(filepath.Dir(pth), base[len(".wh."):])); err != nil {
+				return err
+			}
+		}
+		if err := os.RemoveAll(pth); err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// treeSize walks a directory tree and returns the sum of the sizes of its files.
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(pth string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			size += f.Size()
+		}
+		return nil
+	})
+	return size, err
+}
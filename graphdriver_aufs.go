@@ -0,0 +1,312 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+func init() {
+	registerGraphDriver("aufs", newAufsDriver)
+}
+
+// AufsDriver stacks layers as AUFS branches. Each layer only stores its own
+// changes in diff/<id>, and the union is mounted at mnt/<id> on demand.
+// The ordered list of parents of a layer is kept in layers/<id>.
+type AufsDriver struct {
+	home string
+}
+
+func newAufsDriver(home string) (GraphDriver, error) {
+	if err := supportsAufs(); err != nil {
+		return nil, err
+	}
+	for _, p := range []string{"diff", "mnt", "layers"} {
+		if err := os.MkdirAll(path.Join(home, p), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &AufsDriver{home: home}, nil
+}
+
+// supportsAufs checks that the kernel knows about aufs, loading the
+// module if necessary.
+func supportsAufs() error {
+	if hasFilesystem("aufs") {
+		return nil
+	}
+	log.Printf("Kernel does not support AUFS, trying to load the AUFS module with modprobe...")
+	if err := exec.Command("modprobe", "aufs").Run(); err != nil {
+		return fmt.Errorf("Unable to load the AUFS module")
+	}
+	log.Printf("...module loaded.")
+	if !hasFilesystem("aufs") {
+		return fmt.Errorf("AUFS was not found in /proc/filesystems")
+	}
+	return nil
+}
+
+func hasFilesystem(fstype string) bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasSuffix(s.Text(), "\t"+fstype) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AufsDriver) String() string {
+	return "aufs"
+}
+
+func (a *AufsDriver) diffPath(id string) string {
+	return path.Join(a.home, "diff", id)
+}
+
+func (a *AufsDriver) mntPath(id string) string {
+	return path.Join(a.home, "mnt", id)
+}
+
+func (a *AufsDriver) layersPath(id string) string {
+	return path.Join(a.home, "layers", id)
+}
+
+func (a *AufsDriver) Create(id, parent string) error {
+	if err := os.MkdirAll(a.diffPath(id), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.mntPath(id), 0755); err != nil {
+		return err
+	}
+	// Record the full list of parents, from the closest to the base layer
+	var layers []string
+	if parent != "" {
+		parents, err := a.getParentLayers(parent)
+		if err != nil {
+			return err
+		}
+		layers = append([]string{parent}, parents...)
+	}
+	return ioutil.WriteFile(a.layersPath(id), []byte(strings.Join(layers, "\n")), 0644)
+}
+
+func (a *AufsDriver) Remove(id string) error {
+	if mounted, err := Mounted(a.mntPath(id)); err != nil {
+		return err
+	} else if mounted {
+		if err := Unmount(a.mntPath(id)); err != nil {
+			return err
+		}
+	}
+	for _, p := range []string{a.diffPath(id), a.mntPath(id), a.layersPath(id)} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get mounts the union of the layer and its parents, unless the layer
+// has no parent in which case its diff directory is returned as-is.
+func (a *AufsDriver) Get(id string) (string, error) {
+	layers, err := a.getParentLayers(id)
+	if err != nil {
+		return "", err
+	}
+	if len(layers) == 0 {
+		return a.diffPath(id), nil
+	}
+	target := a.mntPath(id)
+	if mounted, err := Mounted(target); err != nil {
+		return "", err
+	} else if mounted {
+		return target, nil
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+	ro := make([]string, len(layers))
+	for i, layer := range layers {
+		ro[i] = a.diffPath(layer)
+	}
+	if err := MountAUFS(ro, a.diffPath(id), target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func (a *AufsDriver) Put(id string) {
+	if mounted, err := Mounted(a.mntPath(id)); err != nil || !mounted {
+		return
+	}
+	if err := Unmount(a.mntPath(id)); err != nil {
+		log.Printf("Failed to umount layer %s: %s", id, err)
+	}
+}
+
+func (a *AufsDriver) Exists(id string) bool {
+	_, err := os.Stat(a.diffPath(id))
+	return err == nil
+}
+
+func (a *AufsDriver) Diff(id, parent string) (Archive, error) {
+	return Tar(a.diffPath(id), Uncompressed)
+}
+
+func (a *AufsDriver) Changes(id, parent string) ([]Change, error) {
+	parents, err := a.getParentLayers(id)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]string, len(parents))
+	for i, p := range parents {
+		layers[i] = a.diffPath(p)
+	}
+	return Changes(layers, a.diffPath(id))
+}
+
+// ApplyDiff unpacks the archive as-is: AUFS handles the whiteouts natively.
+func (a *AufsDriver) ApplyDiff(id, parent string, diff Archive) error {
+	return Untar(diff, a.diffPath(id), nil)
+}
+
+func (a *AufsDriver) DiffSize(id, parent string) (int64, error) {
+	return treeSize(a.diffPath(id))
+}
+
+// Cleanup unmounts every layer which is still mounted.
+func (a *AufsDriver) Cleanup() error {
+	ids, err := ioutil.ReadDir(path.Join(a.home, "mnt"))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		a.Put(id.Name())
+	}
+	return nil
+}
+
+func (a *AufsDriver) getParentLayers(id string) ([]string, error) {
+	content, err := ioutil.ReadFile(a.layersPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var layers []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			layers = append(layers, line)
+		}
+	}
+	return layers, nil
+}
+
+// migrate moves the layers of a graph and of the containers created before
+// the introduction of storage drivers into the aufs directories.
+// Images used to keep their layer in <graph>/<id>/layer, and containers their
+// rw branch in <containers>/<id>/rw.
+func (a *AufsDriver) migrate(graph *Graph, containersRoot string) error {
+	images, err := ioutil.ReadDir(graph.Root)
+	if err != nil {
+		return err
+	}
+	parents := make(map[string]string)
+	for _, st := range images {
+		id := st.Name()
+		img, err := LoadImage(graph.imageRoot(id))
+		if err != nil {
+			continue
+		}
+		parents[id] = img.Parent
+	}
+	var migrateLayer func(id string) error
+	migrateLayer = func(id string) error {
+		if a.Exists(id) {
+			return nil
+		}
+		oldPath := layerPath(graph.imageRoot(id))
+		if _, err := os.Stat(oldPath); err != nil {
+			return nil
+		}
+		parent := parents[id]
+		if parent != "" {
+			if err := migrateLayer(parent); err != nil {
+				return err
+			}
+		}
+		utils.Debugf("Migrating layer %s to aufs", id)
+		if err := a.Create(id, parent); err != nil {
+			return err
+		}
+		if err := os.Remove(a.diffPath(id)); err != nil {
+			return err
+		}
+		return os.Rename(oldPath, a.diffPath(id))
+	}
+	for id := range parents {
+		if err := migrateLayer(id); err != nil {
+			return err
+		}
+	}
+
+	containers, err := ioutil.ReadDir(containersRoot)
+	if err != nil {
+		return err
+	}
+	for _, st := range containers {
+		id := st.Name()
+		rw := path.Join(containersRoot, id, "rw")
+		if _, err := os.Stat(rw); err != nil || a.Exists(id) {
+			continue
+		}
+		container := &Container{root: path.Join(containersRoot, id)}
+		if err := container.FromDisk(); err != nil {
+			continue
+		}
+		utils.Debugf("Migrating container %s to aufs", id)
+		initID := container.initLayerID()
+		if err := a.Create(initID, container.Image); err != nil {
+			return err
+		}
+		if err := setupInitLayer(a.diffPath(initID)); err != nil {
+			return err
+		}
+		if err := a.Create(id, initID); err != nil {
+			return err
+		}
+		if err := os.Remove(a.diffPath(id)); err != nil {
+			return err
+		}
+		if err := os.Rename(rw, a.diffPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MountAUFS(ro []string, rw string, target string) error {
+	rwBranch := fmt.Sprintf("%v=rw", rw)
+	roBranches := ""
+	for _, layer := range ro {
+		roBranches += fmt.Sprintf("%v=ro+wh:", layer)
+	}
+	branches := fmt.Sprintf("br:%v:%v", rwBranch, roBranches)
+
+	branches += ",xino=/dev/shm/aufs.xino"
+
+	if err := mount("none", target, "aufs", 0, branches); err != nil {
+		return fmt.Errorf("Unable to mount using aufs: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+func init() {
+	registerGraphDriver("btrfs", newBtrfsDriver)
+}
+
+// BtrfsDriver stores each layer as a btrfs subvolume. Child layers are
+// snapshots of their parent, so creating a container is a constant-time
+// operation regardless of the number of layers of its image.
+// The driver root must be on a btrfs filesystem.
+type BtrfsDriver struct {
+	home string
+}
+
+func newBtrfsDriver(home string) (GraphDriver, error) {
+	// Check the filesystem of the parent, since home may not exist yet
+	if err := os.MkdirAll(path.Dir(home), 0700); err != nil {
+		return nil, err
+	}
+	if ok, err := isBtrfs(path.Dir(home)); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%s is not on a btrfs filesystem", path.Dir(home))
+	}
+	if _, err := exec.LookPath("btrfs"); err != nil {
+		return nil, fmt.Errorf("Unable to find the btrfs tool: %s", err)
+	}
+	if err := os.MkdirAll(path.Join(home, "subvolumes"), 0700); err != nil {
+		return nil, err
+	}
+	return &BtrfsDriver{home: home}, nil
+}
+
+func (d *BtrfsDriver) String() string {
+	return "btrfs"
+}
+
+func (d *BtrfsDriver) subvolume(id string) string {
+	return path.Join(d.home, "subvolumes", path.Base(id))
+}
+
+func btrfsCmd(args ...string) error {
+	output, err := exec.Command("btrfs", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("btrfs %s failed: %s (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *BtrfsDriver) Create(id, parent string) error {
+	if parent == "" {
+		return btrfsCmd("subvolume", "create", d.subvolume(id))
+	}
+	if !d.Exists(parent) {
+		return fmt.Errorf("%s: no such subvolume", parent)
+	}
+	return btrfsCmd("subvolume", "snapshot", d.subvolume(parent), d.subvolume(id))
+}
+
+func (d *BtrfsDriver) Remove(id string) error {
+	if !d.Exists(id) {
+		return fmt.Errorf("%s does not exist", d.subvolume(id))
+	}
+	return btrfsCmd("subvolume", "delete", d.subvolume(id))
+}
+
+func (d *BtrfsDriver) Get(id string) (string, error) {
+	dir := d.subvolume(id)
+	if st, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !st.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", dir)
+	}
+	return dir, nil
+}
+
+func (d *BtrfsDriver) Put(id string) {
+	// Nothing to release: subvolumes are always accessible
+}
+
+func (d *BtrfsDriver) Exists(id string) bool {
+	_, err := os.Stat(d.subvolume(id))
+	return err == nil
+}
+
+func (d *BtrfsDriver) Diff(id, parent string) (Archive, error) {
+	return naiveDiff(d, id, parent)
+}
+
+func (d *BtrfsDriver) Changes(id, parent string) ([]Change, error) {
+	return naiveChanges(d, id, parent)
+}
+
+func (d *BtrfsDriver) ApplyDiff(id, parent string, diff Archive) error {
+	return naiveApplyDiff(d, id, diff)
+}
+
+func (d *BtrfsDriver) DiffSize(id, parent string) (int64, error) {
+	return naiveDiffSize(d, id, parent)
+}
+
+func (d *BtrfsDriver) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+func init() {
+	registerGraphDriver("vfs", newVfsDriver)
+}
+
+// VfsDriver stores every layer as a plain directory holding a full copy
+// of its parent. It is slow and wastes space, but works on any filesystem.
+type VfsDriver struct {
+	home string
+}
+
+func newVfsDriver(home string) (GraphDriver, error) {
+	if err := os.MkdirAll(path.Join(home, "dir"), 0700); err != nil {
+		return nil, err
+	}
+	return &VfsDriver{home: home}, nil
+}
+
+func (d *VfsDriver) String() string {
+	return "vfs"
+}
+
+func (d *VfsDriver) dir(id string) string {
+	return path.Join(d.home, "dir", path.Base(id))
+}
+
+func (d *VfsDriver) Create(id, parent string) error {
+	dir := d.dir(id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if parent == "" {
+		return nil
+	}
+	parentDir, err := d.Get(parent)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("%s: %s", parent, err)
+	}
+	if err := CopyWithTar(parentDir, dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (d *VfsDriver) Remove(id string) error {
+	if !d.Exists(id) {
+		return fmt.Errorf("%s does not exist", d.dir(id))
+	}
+	return os.RemoveAll(d.dir(id))
+}
+
+func (d *VfsDriver) Get(id string) (string, error) {
+	dir := d.dir(id)
+	if st, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !st.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", dir)
+	}
+	return dir, nil
+}
+
+func (d *VfsDriver) Put(id string) {
+	// Nothing to release: layers are never mounted
+}
+
+func (d *VfsDriver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+func (d *VfsDriver) Diff(id, parent string) (Archive, error) {
+	return naiveDiff(d, id, parent)
+}
+
+func (d *VfsDriver) Changes(id, parent string) ([]Change, error) {
+	return naiveChanges(d, id, parent)
+}
+
+func (d *VfsDriver) ApplyDiff(id, parent string, diff Archive) error {
+	return naiveApplyDiff(d, id, diff)
+}
+
+func (d *VfsDriver) DiffSize(id, parent string) (int64, error) {
+	return naiveDiffSize(d, id, parent)
+}
+
+func (d *VfsDriver) Cleanup() error {
+	return nil
+}
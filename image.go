@@ -8,11 +8,9 @@ import (
 	"github.com/dotcloud/docker/utils"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -29,8 +27,18 @@ type Image struct {
 	Author          string    `json:"author,omitempty"`
 	Config          *Config   `json:"config,omitempty"`
 	Architecture    string    `json:"architecture,omitempty"`
-	graph           *Graph
-	Size            int64
+	// The images whose layers were merged into this one, newest first
+	Squashed []SquashedImage `json:"squashed,omitempty"`
+	graph    *Graph
+	Size     int64
+}
+
+// SquashedImage records an image whose layer was merged by a squash.
+type SquashedImage struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy []string  `json:"created_by,omitempty"`
 }
 
 func LoadImage(root string) (*Image, error) {
@@ -59,45 +67,17 @@ func LoadImage(root string) (*Image, error) {
 			img.Size = int64(size)
 		}
 	}
-
-	// Check that the filesystem layer exists
-	if stat, err := os.Stat(layerPath(root)); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("Couldn't load image %s: no filesystem layer", img.ID)
-		}
-		return nil, err
-	} else if !stat.IsDir() {
-		return nil, fmt.Errorf("Couldn't load image %s: %s is not a directory", img.ID, layerPath(root))
-	}
 	return img, nil
 }
 
-func StoreImage(img *Image, jsonData []byte, layerData Archive, root string) error {
-	// Check that root doesn't already exist
-	if _, err := os.Stat(root); err == nil {
-		return fmt.Errorf("Image %s already exists", img.ID)
-	} else if !os.IsNotExist(err) {
-		return err
-	}
-	// Store the layer
-	layer := layerPath(root)
-	if err := os.MkdirAll(layer, 0755); err != nil {
-		return err
-	}
-
-	// If layerData is not nil, unpack it into the new layer
-	if layerData != nil {
-		start := time.Now()
-		utils.Debugf("Start untar layer")
-		if err := Untar(layerData, layer); err != nil {
-			return err
-		}
-		utils.Debugf("Untar time: %vs\n", time.Now().Sub(start).Seconds())
-	}
-
+// StoreImage writes the metadata of an image into `root`. The filesystem layer
+// itself is managed by the storage driver of the graph.
+func StoreImage(img *Image, jsonData []byte, root string) error {
 	// If raw json is provided, then use it
 	if jsonData != nil {
-		return ioutil.WriteFile(jsonPath(root), jsonData, 0600)
+		if err := ioutil.WriteFile(jsonPath(root), jsonData, 0600); err != nil {
+			return err
+		}
 	} else { // Otherwise, unmarshal the image
 		jsonData, err := json.Marshal(img)
 		if err != nil {
@@ -112,22 +92,11 @@ func StoreImage(img *Image, jsonData []byte, layerData Archive, root string) err
 }
 
 func StoreSize(img *Image, root string) error {
-	layer := layerPath(root)
-
-	var totalSize int64 = 0
-	filepath.Walk(layer, func(path string, fileInfo os.FileInfo, err error) error {
-		totalSize += fileInfo.Size()
-		return nil
-	})
-	img.Size = totalSize
-
-	if err := ioutil.WriteFile(path.Join(root, "layersize"), []byte(strconv.Itoa(int(totalSize))), 0600); err != nil {
-		return nil
-	}
-
-	return nil
+	return ioutil.WriteFile(path.Join(root, "layersize"), []byte(strconv.Itoa(int(img.Size))), 0600)
 }
 
+// layerPath returns the path where images stored their filesystem layer
+// before the introduction of storage drivers.
 func layerPath(root string) string {
 	return path.Join(root, "layer")
 }
@@ -136,75 +105,20 @@ func jsonPath(root string) string {
 	return path.Join(root, "json")
 }
 
-func MountAUFS(ro []string, rw string, target string) error {
-	// FIXME: Now mount the layers
-	rwBranch := fmt.Sprintf("%v=rw", rw)
-	roBranches := ""
-	for _, layer := range ro {
-		roBranches += fmt.Sprintf("%v=ro+wh:", layer)
-	}
-	branches := fmt.Sprintf("br:%v:%v", rwBranch, roBranches)
-
-	branches += ",xino=/dev/shm/aufs.xino"
-
-	//if error, try to load aufs kernel module
-	if err := mount("none", target, "aufs", 0, branches); err != nil {
-		log.Printf("Kernel does not support AUFS, trying to load the AUFS module with modprobe...")
-		if err := exec.Command("modprobe", "aufs").Run(); err != nil {
-			return fmt.Errorf("Unable to load the AUFS module")
-		}
-		log.Printf("...module loaded.")
-		if err := mount("none", target, "aufs", 0, branches); err != nil {
-			return fmt.Errorf("Unable to mount using aufs")
-		}
-	}
-	return nil
-}
-
 // TarLayer returns a tar archive of the image's filesystem layer.
-func (image *Image) TarLayer(compression Compression) (Archive, error) {
-	layerPath, err := image.layer()
-	if err != nil {
-		return nil, err
-	}
-	return Tar(layerPath, compression)
-}
-
-func (image *Image) Mount(root, rw string) error {
-	if mounted, err := Mounted(root); err != nil {
-		return err
-	} else if mounted {
-		return fmt.Errorf("%s is already mounted", root)
-	}
-	layers, err := image.layers()
-	if err != nil {
-		return err
+func (image *Image) TarLayer() (Archive, error) {
+	if image.graph == nil {
+		return nil, fmt.Errorf("Can't export the layer of unregistered image")
 	}
-	// Create the target directories if they don't exist
-	if err := os.Mkdir(root, 0755); err != nil && !os.IsExist(err) {
-		return err
-	}
-	if err := os.Mkdir(rw, 0755); err != nil && !os.IsExist(err) {
-		return err
-	}
-	if err := MountAUFS(layers, rw, root); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (image *Image) Changes(rw string) ([]Change, error) {
-	layers, err := image.layers()
-	if err != nil {
-		return nil, err
-	}
-	return Changes(layers, rw)
+	return image.graph.driver.Diff(image.ID, image.Parent)
 }
 
 func (image *Image) ShortID() string {
 	return utils.TruncateID(image.ID)
 }
 
+var validImageID = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
 func ValidateID(id string) error {
 	if id == "" {
 		return fmt.Errorf("Image id can't be empty")
@@ -212,6 +126,10 @@ func ValidateID(id string) error {
 	if strings.Contains(id, ":") {
 		return fmt.Errorf("Invalid character in image id: ':'")
 	}
+	// Ids are used as paths, eg. by the graph and ImageLoad
+	if !validImageID.MatchString(id) {
+		return fmt.Errorf("Invalid image id: %s", id)
+	}
 	return nil
 }
 
@@ -240,39 +158,6 @@ func (img *Image) History() ([]*Image, error) {
 	return parents, nil
 }
 
-// layers returns all the filesystem layers needed to mount an image
-// FIXME: @shykes refactor this function with the new error handling
-//        (I'll do it if I have time tonight, I focus on the rest)
-func (img *Image) layers() ([]string, error) {
-	var list []string
-	var e error
-	if err := img.WalkHistory(
-		func(img *Image) (err error) {
-			if layer, err := img.layer(); err != nil {
-				e = err
-			} else if layer != "" {
-				list = append(list, layer)
-			}
-			return err
-		},
-	); err != nil {
-		return nil, err
-	} else if e != nil { // Did an error occur inside the handler?
-		return nil, e
-	}
-	if len(list) == 0 {
-		return nil, fmt.Errorf("No layer found for image %s\n", img.ID)
-	}
-
-	// Inject the dockerinit layer (empty place-holder for mount-binding dockerinit)
-	if dockerinitLayer, err := img.getDockerInitLayer(); err != nil {
-		return nil, err
-	} else {
-		list = append([]string{dockerinitLayer}, list...)
-	}
-	return list, nil
-}
-
 func (img *Image) WalkHistory(handler func(*Image) error) (err error) {
 	currentImg := img
 	for currentImg != nil {
@@ -299,29 +184,6 @@ func (img *Image) GetParent() (*Image, error) {
 	return img.graph.Get(img.Parent)
 }
 
-func (img *Image) getDockerInitLayer() (string, error) {
-	if img.graph == nil {
-		return "", fmt.Errorf("Can't lookup dockerinit layer of unregistered image")
-	}
-	return img.graph.getDockerInitLayer()
-}
-
-func (img *Image) root() (string, error) {
-	if img.graph == nil {
-		return "", fmt.Errorf("Can't lookup root of unregistered image")
-	}
-	return img.graph.imageRoot(img.ID), nil
-}
-
-// Return the path of an image's layer
-func (img *Image) layer() (string, error) {
-	root, err := img.root()
-	if err != nil {
-		return "", err
-	}
-	return layerPath(root), nil
-}
-
 func (img *Image) getParentsSize(size int64) int64 {
 	parentImage, err := img.GetParent()
 	if err != nil || parentImage == nil {
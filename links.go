@@ -0,0 +1,185 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Aliases are written in /etc/hosts and in the names of environment variables
+var validLinkAlias = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// parseLink splits a -link argument of the form name:alias.
+func parseLink(link string) (name, alias string, err error) {
+	parts := strings.Split(link, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Invalid link format: %s (expected name:alias)", link)
+	}
+	if !validLinkAlias.MatchString(parts[1]) {
+		return "", "", fmt.Errorf("Invalid link alias (%s), only [a-zA-Z0-9][a-zA-Z0-9_-] are allowed", parts[1])
+	}
+	return parts[0], parts[1], nil
+}
+
+// envPrefix returns the prefix of the environment variables of a link
+// aliased alias, where the characters which can't be used in a variable
+// name are replaced by _.
+func envPrefix(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(alias))
+}
+
+// Link describes what a container exposes to another container linked to it
+// under the name Alias.
+type Link struct {
+	Alias string
+	IP    string
+	// Private ports, in the "port/proto" form
+	Ports []string
+}
+
+// NewLink describes the network settings of the running container child,
+// as seen by the containers linking to it as alias.
+func NewLink(child *Container, alias string) (*Link, error) {
+	if !child.State.Running {
+		return nil, fmt.Errorf("Cannot link to a non running container: %s", child.Name)
+	}
+	if child.NetworkSettings == nil || child.NetworkSettings.IPAddress == "" {
+		return nil, fmt.Errorf("Cannot link to %s: networking is disabled for this container", child.Name)
+	}
+	link := &Link{
+		Alias: alias,
+		IP:    child.NetworkSettings.IPAddress,
+	}
+	for proto, mapping := range child.NetworkSettings.PortMapping {
+		for private := range mapping {
+			link.Ports = append(link.Ports, private+"/"+strings.ToLower(proto))
+		}
+	}
+	sort.Strings(link.Ports)
+	return link, nil
+}
+
+// ToEnv returns the environment variables describing the link, eg. for a
+// link aliased db to a container exposing 5432/tcp:
+//
+//	DB_PORT=tcp://172.17.0.5:5432
+//	DB_PORT_5432_TCP=tcp://172.17.0.5:5432
+//	DB_PORT_5432_TCP_ADDR=172.17.0.5
+//	DB_PORT_5432_TCP_PORT=5432
+//	DB_PORT_5432_TCP_PROTO=tcp
+func (link *Link) ToEnv() []string {
+	prefix := envPrefix(link.Alias)
+	env := []string{}
+	for i, p := range link.Ports {
+		parts := strings.SplitN(p, "/", 2)
+		port, proto := parts[0], parts[1]
+		url := fmt.Sprintf("%s://%s:%s", proto, link.IP, port)
+		if i == 0 {
+			env = append(env, fmt.Sprintf("%s_PORT=%s", prefix, url))
+		}
+		key := fmt.Sprintf("%s_PORT_%s_%s", prefix, port, strings.ToUpper(proto))
+		env = append(env,
+			fmt.Sprintf("%s=%s", key, url),
+			fmt.Sprintf("%s_ADDR=%s", key, link.IP),
+			fmt.Sprintf("%s_PORT=%s", key, port),
+			fmt.Sprintf("%s_PROTO=%s", key, proto),
+		)
+	}
+	return env
+}
+
+// LinkGraph records the links between containers, from the parent (the
+// container using -link) to its children, by id. It is persisted to disk so
+// that a container can't be removed while others depend on it.
+type LinkGraph struct {
+	path string
+	lock sync.Mutex
+	// parent id -> alias -> child id
+	links map[string]map[string]string
+}
+
+func NewLinkGraph(path string) (*LinkGraph, error) {
+	graph := &LinkGraph{
+		path:  path,
+		links: make(map[string]map[string]string),
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return graph, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &graph.links); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+func (graph *LinkGraph) save() error {
+	data, err := json.Marshal(graph.links)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(graph.path, data, 0600)
+}
+
+// Add records that parentID is linked to childID as alias, replacing any
+// previous link using the same alias.
+func (graph *LinkGraph) Add(parentID, alias, childID string) error {
+	graph.lock.Lock()
+	defer graph.lock.Unlock()
+	children, exists := graph.links[parentID]
+	if !exists {
+		children = make(map[string]string)
+		graph.links[parentID] = children
+	}
+	children[alias] = childID
+	return graph.save()
+}
+
+// Child returns the id of the container linked to parentID as alias, or
+// an empty string.
+func (graph *LinkGraph) Child(parentID, alias string) string {
+	graph.lock.Lock()
+	defer graph.lock.Unlock()
+	return graph.links[parentID][alias]
+}
+
+// Parents returns the ids of the containers linked to childID.
+func (graph *LinkGraph) Parents(childID string) []string {
+	graph.lock.Lock()
+	defer graph.lock.Unlock()
+	var parents []string
+	for parentID, children := range graph.links {
+		for _, id := range children {
+			if id == childID {
+				parents = append(parents, parentID)
+				break
+			}
+		}
+	}
+	sort.Strings(parents)
+	return parents
+}
+
+// RemoveParent forgets all the links of parentID.
+func (graph *LinkGraph) RemoveParent(parentID string) error {
+	graph.lock.Lock()
+	defer graph.lock.Unlock()
+	if _, exists := graph.links[parentID]; !exists {
+		return nil
+	}
+	delete(graph.links, parentID)
+	return graph.save()
+}
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLinkEnv(t *testing.T) {
+	link := &Link{
+		Alias: "db",
+		IP:    "172.17.0.2",
+		Ports: []string{"5432/tcp", "6379/udp"},
+	}
+	env := make(map[string]string)
+	for _, elem := range link.ToEnv() {
+		parts := strings.SplitN(elem, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	expected := map[string]string{
+		"DB_PORT":                "tcp://172.17.0.2:5432",
+		"DB_PORT_5432_TCP":       "tcp://172.17.0.2:5432",
+		"DB_PORT_5432_TCP_ADDR":  "172.17.0.2",
+		"DB_PORT_5432_TCP_PORT":  "5432",
+		"DB_PORT_5432_TCP_PROTO": "tcp",
+		"DB_PORT_6379_UDP_ADDR":  "172.17.0.2",
+	}
+	for key, value := range expected {
+		if env[key] != value {
+			t.Errorf("Expected %s=%s, got %s", key, value, env[key])
+		}
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	name, alias, err := parseLink("postgres:my-db_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "postgres" || alias != "my-db_1" {
+		t.Fatalf("Expected postgres and my-db_1, got %s and %s", name, alias)
+	}
+	for _, link := range []string{"postgres", "postgres:", ":db", "a:b:c", "postgres:a b", "postgres:db\n10.0.0.1 evil", "postgres:x=FOO", "postgres:my.db", "postgres:-db"} {
+		if _, _, err := parseLink(link); err == nil {
+			t.Fatalf("%q should not be a valid link", link)
+		}
+	}
+}
+
+func TestLinkEnvPrefix(t *testing.T) {
+	for alias, expected := range map[string]string{"db": "DB", "my-db": "MY_DB", "my.db": "MY_DB", "x=FOO": "X_FOO", "db 2": "DB_2"} {
+		if prefix := envPrefix(alias); prefix != expected {
+			t.Errorf("Expected the prefix %s for %q, got %s", expected, alias, prefix)
+		}
+	}
+}
+
+func TestLinkGraph(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	graph, err := NewLinkGraph(path.Join(tmp, "linkgraph.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Add("parent", "db", "child"); err != nil {
+		t.Fatal(err)
+	}
+	if parents := graph.Parents("child"); len(parents) != 1 || parents[0] != "parent" {
+		t.Fatalf("Expected [parent], got %v", parents)
+	}
+
+	// The graph must survive a restart
+	graph, err = NewLinkGraph(path.Join(tmp, "linkgraph.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := graph.Child("parent", "db"); id != "child" {
+		t.Fatalf("Expected child, got %s", id)
+	}
+	if err := graph.RemoveParent("parent"); err != nil {
+		t.Fatal(err)
+	}
+	if parents := graph.Parents("child"); len(parents) != 0 {
+		t.Fatalf("Expected no parents, got %v", parents)
+	}
+}
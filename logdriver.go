@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+)
+
+// A LogDriver receives the output of a container, one line at a time, along
+// with the name of its stream (stdout or stderr) and the time it was written at.
+type LogDriver interface {
+	utils.LogWriter
+	String() string
+}
+
+type logDriverInitFunc func(container *Container, logConfig LogConfig) (LogDriver, error)
+
+var logDrivers = make(map[string]logDriverInitFunc)
+
+func registerLogDriver(name string, initFunc logDriverInitFunc) {
+	if _, exists := logDrivers[name]; exists {
+		panic(fmt.Sprintf("Log driver %s registered twice", name))
+	}
+	logDrivers[name] = initFunc
+}
+
+func init() {
+	registerLogDriver("none", func(container *Container, logConfig LogConfig) (LogDriver, error) {
+		return nil, nil
+	})
+}
+
+// ValidateLogDriver returns an error if no log driver is registered as name.
+func ValidateLogDriver(name string) error {
+	if _, exists := logDrivers[name]; !exists {
+		return fmt.Errorf("No such log driver: %s", name)
+	}
+	return nil
+}
+
+// NewLogDriver returns the log driver requested by logConfig for container,
+// or nil if its output must be discarded.
+func NewLogDriver(container *Container, logConfig LogConfig) (LogDriver, error) {
+	if err := ValidateLogDriver(logConfig.Type); err != nil {
+		return nil, err
+	}
+	return logDrivers[logConfig.Type](container, logConfig)
+}
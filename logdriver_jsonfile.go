@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"encoding/json"
+	"github.com/dotcloud/docker/utils"
+)
+
+func init() {
+	registerLogDriver("json-file", newJSONFileLogDriver)
+}
+
+// JSONFileLogDriver writes each line as a utils.JSONLog in <id>-json.log,
+// which is rotated according to the MaxSize and MaxFiles of the LogConfig.
+// It is the only driver whose logs can be read back with docker logs.
+type JSONFileLogDriver struct {
+	file *utils.RotatingFile
+}
+
+func newJSONFileLogDriver(container *Container, logConfig LogConfig) (LogDriver, error) {
+	file, err := utils.NewRotatingFile(container.logPath("json"), logConfig.MaxSize, logConfig.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileLogDriver{file: file}, nil
+}
+
+func (d *JSONFileLogDriver) String() string {
+	return "json-file"
+}
+
+// WriteLog writes the entry and its newline at once, so that lines are
+// never mixed between streams nor split by a rotation.
+func (d *JSONFileLogDriver) WriteLog(l *utils.JSONLog) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = d.file.Write(append(b, '\n'))
+	return err
+}
+
+func (d *JSONFileLogDriver) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerLogDriver("syslog", newSyslogLogDriver)
+}
+
+const (
+	syslogFacilityDaemon = 3 << 3
+	syslogSeverityErr    = 3
+	syslogSeverityInfo   = 6
+)
+
+// Paths of the local syslog socket, tried in this order when no address is given
+var syslogLocalPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogLogDriver sends each line to syslog with the daemon facility, stdout
+// with the info severity and stderr with the err severity. Messages are
+// tagged with docker/<container name>, and keep the time the line was
+// written at.
+type SyslogLogDriver struct {
+	sync.Mutex
+	conn     net.Conn
+	tag      string
+	hostname string
+}
+
+func newSyslogLogDriver(container *Container, logConfig LogConfig) (LogDriver, error) {
+	conn, err := dialSyslog(logConfig.SyslogAddress)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	name := container.Name
+	if name == "" {
+		name = container.ShortID()
+	}
+	return &SyslogLogDriver{
+		conn:     conn,
+		tag:      "docker/" + name,
+		hostname: hostname,
+	}, nil
+}
+
+// dialSyslog connects to the syslog daemon at address, which is either
+// unix:///path/to/socket or udp://host[:port]. An empty address means the
+// local syslog daemon.
+func dialSyslog(address string) (net.Conn, error) {
+	if address == "" {
+		for _, pth := range syslogLocalPaths {
+			if conn, err := dialSyslogUnix(pth); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, fmt.Errorf("Unable to connect to the local syslog daemon")
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		return dialSyslogUnix(u.Path)
+	case "udp":
+		host := u.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "514")
+		}
+		return net.Dial("udp", host)
+	}
+	return nil, fmt.Errorf("Invalid syslog address: %s (expected unix:///path or udp://host:port)", address)
+}
+
+func dialSyslogUnix(pth string) (net.Conn, error) {
+	conn, err := net.Dial("unixgram", pth)
+	if err != nil {
+		utils.Debugf("Unable to connect to syslog at %s with unixgram: %s", pth, err)
+		return net.Dial("unix", pth)
+	}
+	return conn, nil
+}
+
+func (d *SyslogLogDriver) String() string {
+	return "syslog"
+}
+
+func (d *SyslogLogDriver) WriteLog(l *utils.JSONLog) error {
+	priority := syslogFacilityDaemon | syslogSeverityInfo
+	if l.Stream == "stderr" {
+		priority = syslogFacilityDaemon | syslogSeverityErr
+	}
+	msg := strings.TrimSuffix(l.Log, "\n")
+	d.Lock()
+	defer d.Unlock()
+	if d.conn == nil {
+		return fmt.Errorf("The syslog connection is closed")
+	}
+	_, err := fmt.Fprintf(d.conn, "<%d>%s %s %s: %s\n", priority, l.Created.Format(time.Stamp), d.hostname, d.tag, msg)
+	return err
+}
+
+// Close closes the connection to syslog. It can safely be called several times.
+func (d *SyslogLogDriver) Close() error {
+	d.Lock()
+	defer d.Unlock()
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
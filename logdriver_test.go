@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"encoding/json"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONFileLogDriver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-logdriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	container := &Container{ID: "foo", root: tmp}
+	driver, err := NewLogDriver(container, LogConfig{Type: "json-file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now()
+	if err := driver.WriteLog(&utils.JSONLog{Log: "hello\n", Stream: "stderr", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(container.logPath("json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l utils.JSONLog
+	if err := json.Unmarshal(data, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Log != "hello\n" || l.Stream != "stderr" || !l.Created.Equal(created) {
+		t.Fatalf("Unexpected log entry: %#v", l)
+	}
+}
+
+func TestSyslogLogDriver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-logdriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	socket := path.Join(tmp, "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	container := &Container{ID: "foo", Name: "web"}
+	driver, err := NewLogDriver(container, LogConfig{Type: "syslog", SyslogAddress: "unix://" + socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	if err := driver.WriteLog(&utils.JSONLog{Log: "oops\n", Stream: "stderr", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// daemon facility (3) with err severity (3)
+	if !strings.HasPrefix(msg, "<27>") || !strings.HasSuffix(msg, " docker/web: oops\n") {
+		t.Fatalf("Unexpected syslog message: %q", msg)
+	}
+}
+
+func TestNoneLogDriver(t *testing.T) {
+	driver, err := NewLogDriver(&Container{ID: "foo"}, LogConfig{Type: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver != nil {
+		t.Fatalf("The none log driver should discard the logs")
+	}
+	if _, err := NewLogDriver(&Container{ID: "foo"}, LogConfig{Type: "carrier-pigeon"}); err == nil {
+		t.Fatal("Unknown log drivers should be rejected")
+	}
+}
+
+func TestValidateLogDriver(t *testing.T) {
+	for _, name := range []string{"json-file", "syslog", "none"} {
+		if err := ValidateLogDriver(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"", "jsonfile", "carrier-pigeon"} {
+		if err := ValidateLogDriver(name); err == nil {
+			t.Fatalf("%q should not be a valid log driver", name)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package docker
 
 import (
+	"fmt"
+	"strings"
 	"text/template"
 )
 
@@ -22,6 +24,7 @@ lxc.network.type = veth
 lxc.network.flags = up
 lxc.network.link = {{.NetworkSettings.Bridge}}
 lxc.network.name = eth0
+lxc.network.veth.pair = {{getVethName .}}
 lxc.network.mtu = 1500
 lxc.network.ipv4 = {{.NetworkSettings.IPAddress}}/{{.NetworkSettings.IPPrefixLen}}
 {{end}}
@@ -29,6 +32,11 @@ lxc.network.ipv4 = {{.NetworkSettings.IPAddress}}/{{.NetworkSettings.IPPrefixLen
 # root filesystem
 {{$ROOTFS := .RootfsPath}}
 lxc.rootfs = {{$ROOTFS}}
+{{$hostConfig := getHostConfig .}}
+{{if $hostConfig.ReadonlyRootfs}}
+# read-only root filesystem, the mounts below stay writable (-read-only)
+lxc.mount.entry = {{$ROOTFS}} {{$ROOTFS}} none bind,ro 0 0
+{{end}}
 
 {{if and .HostnamePath .HostsPath}}
 # enable domain name support
@@ -78,6 +86,11 @@ lxc.cgroup.devices.allow = c 10:200 rwm
 
 # rtc
 #lxc.cgroup.devices.allow = c 254:0 rwm
+
+# devices added with -device
+{{range $device := getDevices .}}
+lxc.cgroup.devices.allow = {{$device.Type}} {{$device.Major}}:{{$device.Minor}} {{$device.CgroupPermissions}}
+{{end}}
 {{end}}
 
 # standard mount point
@@ -104,14 +117,22 @@ lxc.mount.entry = {{$realPath}} {{$ROOTFS}}/{{$virtualPath}} none bind,{{ if ind
 {{end}}
 {{end}}
 
-{{if .Config.Privileged}}
-# retain all capabilities; no lxc.cap.drop line
-{{else}}
+{{range $path, $options := $hostConfig.Tmpfs}}
+lxc.mount.entry = tmpfs {{$ROOTFS}}{{$path}} tmpfs {{tmpfsOptions $options}} 0 0
+{{end}}
+
+{{range $device := getDevices .}}
+lxc.mount.entry = {{$device.NodePath}} {{$ROOTFS}}{{$device.PathInContainer}} none bind 0 0
+{{end}}
+
+{{with $capDrop := getCapDrop .}}
 # drop linux capabilities (apply mainly to the user root in the container)
 #  (Note: 'lxc.cap.keep' is coming soon and should replace this under the
 #         security principle 'deny all unless explicitly permitted', see
 #         http://sourceforge.net/mailarchive/message.php?msg_id=31054627 )
-lxc.cap.drop = audit_control audit_write mac_admin mac_override mknod setfcap setpcap sys_admin sys_boot sys_module sys_nice sys_pacct sys_rawio sys_resource sys_time sys_tty_config
+lxc.cap.drop = {{join $capDrop " "}}
+{{else}}
+# retain all capabilities; no lxc.cap.drop line
 {{end}}
 
 # limits
@@ -125,6 +146,12 @@ lxc.cgroup.memory.memsw.limit_in_bytes = {{$memSwap}}
 {{if .Config.CpuShares}}
 lxc.cgroup.cpu.shares = {{.Config.CpuShares}}
 {{end}}
+{{if .Config.Cpuset}}
+lxc.cgroup.cpuset.cpus = {{.Config.Cpuset}}
+{{end}}
+{{if .Config.BlkioWeight}}
+lxc.cgroup.blkio.weight = {{.Config.BlkioWeight}}
+{{end}}
 `
 
 const LxcHostConfigTemplate = `
@@ -147,10 +174,162 @@ func getMemorySwap(config *Config) int64 {
 	return config.Memory * 2
 }
 
+// Capabilities known by lxc, in the order of their numbers
+var linuxCapabilities = []string{
+	"chown",
+	"dac_override",
+	"dac_read_search",
+	"fowner",
+	"fsetid",
+	"kill",
+	"setgid",
+	"setuid",
+	"setpcap",
+	"linux_immutable",
+	"net_bind_service",
+	"net_broadcast",
+	"net_admin",
+	"net_raw",
+	"ipc_lock",
+	"ipc_owner",
+	"sys_module",
+	"sys_rawio",
+	"sys_chroot",
+	"sys_ptrace",
+	"sys_pacct",
+	"sys_admin",
+	"sys_boot",
+	"sys_nice",
+	"sys_resource",
+	"sys_time",
+	"sys_tty_config",
+	"mknod",
+	"lease",
+	"audit_write",
+	"audit_control",
+	"setfcap",
+	"mac_override",
+	"mac_admin",
+	"syslog",
+	"wake_alarm",
+	"block_suspend",
+}
+
+// Capabilities dropped in unprivileged containers, unless added with -cap-add
+var defaultCapDrop = []string{
+	"audit_control",
+	"audit_write",
+	"mac_admin",
+	"mac_override",
+	"mknod",
+	"setfcap",
+	"setpcap",
+	"sys_admin",
+	"sys_boot",
+	"sys_module",
+	"sys_nice",
+	"sys_pacct",
+	"sys_rawio",
+	"sys_resource",
+	"sys_time",
+	"sys_tty_config",
+}
+
+// normalizeCapability returns the lxc name of a capability given as eg.
+// NET_ADMIN, CAP_NET_ADMIN or net_admin. ALL is returned as "all".
+func normalizeCapability(name string) (string, error) {
+	capability := strings.TrimPrefix(strings.ToLower(name), "cap_")
+	if capability == "all" {
+		return capability, nil
+	}
+	for _, known := range linuxCapabilities {
+		if capability == known {
+			return capability, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown capability: %s", name)
+}
+
+// droppedCapabilities returns the capabilities to drop in a container. It
+// starts from defaultCapDrop, or from no capability for privileged
+// containers, then keeps the capabilities of capAdd and drops the ones of
+// capDrop. ALL in capAdd keeps all the capabilities not in capDrop, and
+// ALL in capDrop drops all of them but the ones of capAdd.
+func droppedCapabilities(privileged bool, capAdd, capDrop []string) ([]string, error) {
+	add := make(map[string]bool)
+	for _, name := range capAdd {
+		capability, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		add[capability] = true
+	}
+	drop := make(map[string]bool)
+	for _, name := range capDrop {
+		capability, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if add[capability] {
+			return nil, fmt.Errorf("Conflicting options: -cap-add and -cap-drop of %s", name)
+		}
+		drop[capability] = true
+	}
+
+	base := defaultCapDrop
+	if privileged || add["all"] {
+		base = nil
+	}
+	if drop["all"] {
+		base = linuxCapabilities
+	}
+	for _, capability := range base {
+		drop[capability] = true
+	}
+	var dropped []string
+	for _, capability := range linuxCapabilities {
+		if drop[capability] && !add[capability] {
+			dropped = append(dropped, capability)
+		}
+	}
+	return dropped, nil
+}
+
+func getCapDrop(container *Container) []string {
+	return container.capDrop
+}
+
+func getDevices(container *Container) []*device {
+	return container.devices
+}
+
+// getVethName returns the name of the host side of the veth interface of the
+// container, limited to the 15 characters of a network interface name.
+func getVethName(container *Container) string {
+	id := container.ID
+	if len(id) > 11 {
+		id = id[:11]
+	}
+	return "veth" + id
+}
+
+func getHostConfig(container *Container) *HostConfig {
+	if container.hostConfig == nil {
+		return &HostConfig{}
+	}
+	return container.hostConfig
+}
+
 func init() {
 	var err error
 	funcMap := template.FuncMap{
 		"getMemorySwap": getMemorySwap,
+		"getCapDrop":    getCapDrop,
+		"getDevices":    getDevices,
+		"getHostConfig": getHostConfig,
+		"getVethName":   getVethName,
+		"tmpfsOptions":  tmpfsOptions,
+		"join":          strings.Join,
 	}
 	LxcTemplateCompiled, err = template.New("lxc").Funcs(funcMap).Parse(LxcTemplate)
 	if err != nil {
@@ -2,6 +2,18 @@ package docker
 
 import "syscall"
 
+// Filesystem magic number of btrfs, as found in linux/magic.h
+const btrfsSuperMagic = 0x9123683E
+
 func mount(source string, target string, fstype string, flags uintptr, data string) (err error) {
 	return syscall.Mount(source, target, fstype, flags, data)
 }
+
+// isBtrfs returns true if `path` lives on a btrfs filesystem.
+func isBtrfs(path string) (bool, error) {
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(path, &buf); err != nil {
+		return false, err
+	}
+	return buf.Type == btrfsSuperMagic, nil
+}
@@ -0,0 +1,272 @@
+// Package parser reads Dockerfiles into a list of instructions, reporting
+// syntax errors with their position in the file.
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instructions lists the instructions known by the builder, lowercased.
+var Instructions = []string{
+	"from",
+	"maintainer",
+	"run",
+	"cmd",
+	"expose",
+	"env",
+	"add",
+	"entrypoint",
+	"volume",
+	"user",
+	"workdir",
+	"onbuild",
+	"arg",
+}
+
+// Instructions accepting a JSON array of strings as arguments
+var jsonInstructions = map[string]bool{
+	"run":        true,
+	"cmd":        true,
+	"entrypoint": true,
+	"volume":     true,
+}
+
+// Instructions which are not supported anymore, with the reason why
+var deprecatedInstructions = map[string]string{
+	"insert": "INSERT has been deprecated. Please use ADD instead",
+	"copy":   "COPY has been deprecated. Please use ADD instead",
+}
+
+// Node is an instruction of a Dockerfile.
+type Node struct {
+	// Line of the Dockerfile where the instruction starts
+	Line int
+	// Lowercased name of the instruction, eg. "run"
+	Instruction string
+	// Arguments as written, with the continuation lines joined
+	Raw string
+	// The elements of the array for the JSON form, the whitespace
+	// separated words otherwise
+	Args []string
+	// True if the arguments were given as a JSON array
+	JSON bool
+	// Instruction triggered by ONBUILD
+	Next *Node
+}
+
+// String returns the instruction as it would be written in a Dockerfile.
+func (n *Node) String() string {
+	return strings.ToUpper(n.Instruction) + " " + n.Raw
+}
+
+// Dockerfile is the parsed content of a Dockerfile.
+type Dockerfile struct {
+	Nodes []*Node
+}
+
+// SyntaxError describes an invalid instruction. Line and Column start at 1.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("Dockerfile:%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ErrorList holds all the syntax errors of a Dockerfile.
+type ErrorList []*SyntaxError
+
+func (list ErrorList) Error() string {
+	msgs := make([]string, len(list))
+	for i, err := range list {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Parse reads a Dockerfile. Blank lines and lines starting with # are
+// ignored, and lines ending with a backslash continue on the next line.
+// Parsing doesn't stop at the first invalid instruction: the returned
+// error is an ErrorList of all of them.
+func Parse(r io.Reader) (*Dockerfile, error) {
+	var (
+		dockerfile = &Dockerfile{}
+		errors     ErrorList
+		scanner    = bufio.NewScanner(r)
+		lineno     = 0
+		seenFrom   = false
+	)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if isBlank(line) {
+			continue
+		}
+		// Join the continuation lines
+		start := lineno
+		for strings.HasSuffix(strings.TrimRight(line, " \t\r"), "\\") {
+			line = strings.TrimRight(line, " \t\r")
+			line = strings.TrimRight(line[:len(line)-1], " \t")
+			if !scanner.Scan() {
+				break
+			}
+			lineno++
+			line += strings.TrimRight(scanner.Text(), "\r")
+		}
+		node, err := parseLine(line, start)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		// Only ARG may come before FROM, so that the base image can
+		// depend on a build argument
+		if !seenFrom && node.Instruction != "arg" && node.Instruction != "from" {
+			errors = append(errors, &SyntaxError{start, column(line, 0), "The first instruction must be FROM"})
+			seenFrom = true
+		}
+		if node.Instruction == "from" {
+			seenFrom = true
+		}
+		dockerfile.Nodes = append(dockerfile.Nodes, node)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(dockerfile.Nodes) == 0 && len(errors) == 0 {
+		errors = append(errors, &SyntaxError{1, 1, "The Dockerfile has no instructions"})
+	}
+	if len(errors) > 0 {
+		return dockerfile, errors
+	}
+	return dockerfile, nil
+}
+
+// ParseInstruction parses a single instruction, eg. an ONBUILD trigger.
+func ParseInstruction(line string) (*Node, error) {
+	node, err := parseLine(line, 1)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func isBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || line[0] == '#'
+}
+
+// column returns the 1-based column of the first non blank character of
+// line after offset.
+func column(line string, offset int) int {
+	return offset + len(line[offset:]) - len(strings.TrimLeft(line[offset:], " \t")) + 1
+}
+
+func parseLine(line string, lineno int) (*Node, *SyntaxError) {
+	instrCol := column(line, 0)
+	rest := strings.TrimLeft(line, " \t")
+	word := rest
+	if i := strings.IndexAny(rest, " \t"); i >= 0 {
+		word = rest[:i]
+	}
+	node := &Node{
+		Line:        lineno,
+		Instruction: strings.ToLower(word),
+		Raw:         strings.TrimSpace(rest[len(word):]),
+	}
+	argsCol := column(line, instrCol-1+len(word))
+	fail := func(col int, format string, a ...interface{}) (*Node, *SyntaxError) {
+		return nil, &SyntaxError{lineno, col, fmt.Sprintf(format, a...)}
+	}
+
+	if msg, deprecated := deprecatedInstructions[node.Instruction]; deprecated {
+		return fail(instrCol, "%s", msg)
+	}
+	if !isInstruction(node.Instruction) {
+		return fail(instrCol, "Unknown instruction: %s", strings.ToUpper(word))
+	}
+	name := strings.ToUpper(node.Instruction)
+	if node.Raw == "" {
+		return fail(argsCol, "%s requires arguments", name)
+	}
+
+	if jsonInstructions[node.Instruction] && strings.HasPrefix(node.Raw, "[") {
+		// Arguments which are not a valid array, eg. RUN [ -e /foo ],
+		// are in the shell form
+		var args []string
+		if err := json.Unmarshal([]byte(node.Raw), &args); err == nil {
+			if len(args) == 0 {
+				return fail(argsCol, "%s requires arguments", name)
+			}
+			node.Args = args
+			node.JSON = true
+		}
+	}
+	if !node.JSON {
+		node.Args = strings.Fields(node.Raw)
+	}
+
+	switch node.Instruction {
+	case "from":
+		if len(node.Args) != 1 {
+			return fail(argsCol, "%s requires exactly one argument", name)
+		}
+	case "env":
+		if len(node.Args) < 2 {
+			return fail(argsCol, "ENV requires a name and a value")
+		}
+	case "add":
+		if len(node.Args) < 2 {
+			return fail(argsCol, "ADD requires a source and a destination")
+		}
+	case "arg":
+		if len(node.Args) != 1 {
+			return fail(argsCol, "ARG requires exactly one argument, name[=default]")
+		}
+		if name := strings.SplitN(node.Args[0], "=", 2)[0]; !isArgName(name) {
+			return fail(argsCol, "Invalid ARG name: %s", name)
+		}
+	case "onbuild":
+		next, err := parseLine(node.Raw, lineno)
+		if err != nil {
+			err.Column += argsCol - 1
+			return nil, err
+		}
+		switch next.Instruction {
+		case "onbuild":
+			return fail(argsCol, "Chaining ONBUILD via `ONBUILD ONBUILD` isn't allowed")
+		case "from", "maintainer", "arg":
+			return fail(argsCol, "%s isn't allowed as an ONBUILD trigger", strings.ToUpper(next.Instruction))
+		}
+		node.Next = next
+	}
+	return node, nil
+}
+
+func isInstruction(name string) bool {
+	for _, instruction := range Instructions {
+		if name == instruction {
+			return true
+		}
+	}
+	return false
+}
+
+// isArgName returns true if name can be used as a build argument, ie. it is
+// made of letters, digits and underscores.
+func isArgName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
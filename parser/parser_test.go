@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile, err := Parse(strings.NewReader(`# A comment
+from   busybox
+maintainer Solomon Hykes <solomon@dotcloud.com>
+
+run    sh -c 'echo root:testpass \
+	> /tmp/passwd'
+RUN ["echo", "hello"]
+run [ "$(cat /foo)" = "hello" ]
+	env FOO bar baz
+CMD ["/bin/sh"]
+onbuild add . /app
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Node{
+		{Line: 2, Instruction: "from", Raw: "busybox", Args: []string{"busybox"}},
+		{Line: 3, Instruction: "maintainer", Raw: "Solomon Hykes <solomon@dotcloud.com>", Args: []string{"Solomon", "Hykes", "<solomon@dotcloud.com>"}},
+		{Line: 5, Instruction: "run", Raw: "sh -c 'echo root:testpass\t> /tmp/passwd'", Args: []string{"sh", "-c", "'echo", "root:testpass", ">", "/tmp/passwd'"}},
+		{Line: 7, Instruction: "run", Raw: `["echo", "hello"]`, Args: []string{"echo", "hello"}, JSON: true},
+		{Line: 8, Instruction: "run", Raw: `[ "$(cat /foo)" = "hello" ]`, Args: []string{"[", `"$(cat`, `/foo)"`, "=", `"hello"`, "]"}},
+		{Line: 9, Instruction: "env", Raw: "FOO bar baz", Args: []string{"FOO", "bar", "baz"}},
+		{Line: 10, Instruction: "cmd", Raw: `["/bin/sh"]`, Args: []string{"/bin/sh"}, JSON: true},
+		{Line: 11, Instruction: "onbuild", Raw: "add . /app", Args: []string{"add", ".", "/app"},
+			Next: &Node{Line: 11, Instruction: "add", Raw: ". /app", Args: []string{".", "/app"}}},
+	}
+	if len(dockerfile.Nodes) != len(expected) {
+		t.Fatalf("Expected %d instructions, found %d", len(expected), len(dockerfile.Nodes))
+	}
+	for i, node := range dockerfile.Nodes {
+		if !reflect.DeepEqual(node, expected[i]) {
+			t.Errorf("Instruction %d:\nhave %#v\nwant %#v", i, node, expected[i])
+		}
+	}
+	if s := dockerfile.Nodes[6].String(); s != `CMD ["/bin/sh"]` {
+		t.Errorf("Unexpected String(): %s", s)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader(`from busybox
+  frob foo
+run
+add foo
+insert foo /bar
+ONBUILD  ONBUILD run true
+onbuild FROM busybox
+onbuild   bar
+cmd []
+from busybox ubuntu
+arg A B
+arg $A=1
+`))
+	errors, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected an ErrorList, found %#v", err)
+	}
+	expected := []string{
+		"Dockerfile:2:3: Unknown instruction: FROB",
+		"Dockerfile:3:4: RUN requires arguments",
+		"Dockerfile:4:5: ADD requires a source and a destination",
+		"Dockerfile:5:1: INSERT has been deprecated. Please use ADD instead",
+		"Dockerfile:6:10: Chaining ONBUILD via `ONBUILD ONBUILD` isn't allowed",
+		"Dockerfile:7:9: FROM isn't allowed as an ONBUILD trigger",
+		"Dockerfile:8:11: Unknown instruction: BAR",
+		"Dockerfile:9:5: CMD requires arguments",
+		"Dockerfile:10:6: FROM requires exactly one argument",
+		"Dockerfile:11:5: ARG requires exactly one argument, name[=default]",
+		"Dockerfile:12:5: Invalid ARG name: $A",
+	}
+	if len(errors) != len(expected) {
+		t.Fatalf("Expected %d errors, found:\n%s", len(expected), errors)
+	}
+	for i, err := range errors {
+		if err.Error() != expected[i] {
+			t.Errorf("Expected %q, found %q", expected[i], err)
+		}
+	}
+}
+
+func TestParseFirstInstruction(t *testing.T) {
+	for dockerfile, expected := range map[string]string{
+		"":                       "Dockerfile:1:1: The Dockerfile has no instructions",
+		"# only a comment\n":     "Dockerfile:1:1: The Dockerfile has no instructions",
+		"\n  run true\nfrom a\n": "Dockerfile:2:3: The first instruction must be FROM",
+		"arg V=1\nrun true\n":    "Dockerfile:2:1: The first instruction must be FROM",
+	} {
+		if _, err := Parse(strings.NewReader(dockerfile)); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, found %v", dockerfile, expected, err)
+		}
+	}
+}
+
+func TestParseArgBeforeFrom(t *testing.T) {
+	dockerfile, err := Parse(strings.NewReader("ARG VERSION=12.04\nFROM ubuntu:$VERSION\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dockerfile.Nodes) != 2 || dockerfile.Nodes[0].Instruction != "arg" || dockerfile.Nodes[0].Args[0] != "VERSION=12.04" {
+		t.Fatalf("Unexpected instructions: %#v", dockerfile.Nodes)
+	}
+}
+
+func TestParseInstruction(t *testing.T) {
+	node, err := ParseInstruction("RUN make -C /app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Instruction != "run" || node.Raw != "make -C /app" {
+		t.Fatalf("Unexpected instruction: %#v", node)
+	}
+	if _, err := ParseInstruction("FOO bar"); err == nil {
+		t.Fatalf("Unknown instructions should be refused")
+	}
+}
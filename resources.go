@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Ulimit is a resource limit of the processes of a container, as set by
+// setrlimit(2). Soft can be raised up to Hard by the processes.
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// Resources which can be limited with -ulimit, by name
+var ulimitResources = map[string]int{
+	"core":       syscall.RLIMIT_CORE,
+	"cpu":        syscall.RLIMIT_CPU,
+	"data":       syscall.RLIMIT_DATA,
+	"fsize":      syscall.RLIMIT_FSIZE,
+	"stack":      syscall.RLIMIT_STACK,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     syscall.RLIMIT_NOFILE,
+	"memlock":    8,
+	"as":         syscall.RLIMIT_AS,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+// parseUlimit parses a -ulimit argument of the form name=soft[:hard]. The
+// hard limit defaults to the soft one.
+func parseUlimit(value string) (*Ulimit, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid ulimit format: %s (expected name=soft[:hard])", value)
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := strconv.ParseInt(limits[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ulimit format: %s (expected name=soft[:hard])", value)
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = strconv.ParseInt(limits[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid ulimit format: %s (expected name=soft[:hard])", value)
+		}
+	}
+	ulimit := &Ulimit{Name: parts[0], Soft: soft, Hard: hard}
+	if err := ulimit.validate(); err != nil {
+		return nil, err
+	}
+	return ulimit, nil
+}
+
+func (u *Ulimit) validate() error {
+	if _, exists := ulimitResources[u.Name]; !exists {
+		return fmt.Errorf("Invalid ulimit: unknown resource %s", u.Name)
+	}
+	if u.Soft < 0 || u.Hard < 0 {
+		return fmt.Errorf("Invalid ulimit %s: limits can't be negative", u.Name)
+	}
+	if u.Soft > u.Hard {
+		return fmt.Errorf("Invalid ulimit %s: the soft limit %d is above the hard limit %d", u.Name, u.Soft, u.Hard)
+	}
+	return nil
+}
+
+// String returns the ulimit in the form accepted by parseUlimit.
+func (u *Ulimit) String() string {
+	return fmt.Sprintf("%s=%d:%d", u.Name, u.Soft, u.Hard)
+}
+
+// setrlimit applies the limit to the current process.
+func (u *Ulimit) setrlimit() error {
+	return syscall.Setrlimit(ulimitResources[u.Name], &syscall.Rlimit{Cur: uint64(u.Soft), Max: uint64(u.Hard)})
+}
+
+// ulimitsLock serializes the changes of the hard limits of the daemon by
+// startWithUlimits.
+var ulimitsLock sync.Mutex
+
+// startWithUlimits starts cmd with hard limits at least as high as the ones
+// of ulimits. dockerinit sets the ulimits in the container, after lxc dropped
+// sys_resource, so it can lower the hard limits it inherits but not raise
+// them. The hard limits of the daemon are raised only while cmd is started,
+// and its soft limits are kept.
+func startWithUlimits(cmd *exec.Cmd, ulimits []Ulimit) error {
+	ulimitsLock.Lock()
+	defer ulimitsLock.Unlock()
+	saved := make(map[int]syscall.Rlimit)
+	defer func() {
+		for resource, rlimit := range saved {
+			if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+				utils.Debugf("Unable to restore the limit %d of the daemon: %s", resource, err)
+			}
+		}
+	}()
+	for _, ulimit := range ulimits {
+		resource := ulimitResources[ulimit.Name]
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &rlimit); err != nil {
+			return err
+		}
+		if rlimit.Max >= uint64(ulimit.Hard) {
+			continue
+		}
+		if _, exists := saved[resource]; !exists {
+			saved[resource] = rlimit
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rlimit.Cur, Max: uint64(ulimit.Hard)}); err != nil {
+			return fmt.Errorf("Unable to set ulimit %s: %s", ulimit.String(), err)
+		}
+	}
+	return cmd.Start()
+}
+
+// validateCpuset checks a list of CPUs in the format of cpuset.cpus, eg.
+// "0-2,4".
+func validateCpuset(cpuset string) error {
+	for _, cpus := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(cpus, "-", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid cpuset: %s (expected a list of CPUs, eg. 0-2,4)", cpuset)
+		}
+		if len(bounds) == 2 {
+			last, err := strconv.ParseUint(bounds[1], 10, 16)
+			if err != nil || last < first {
+				return fmt.Errorf("Invalid cpuset: %s (expected a list of CPUs, eg. 0-2,4)", cpuset)
+			}
+		}
+	}
+	return nil
+}
+
+// validateResources checks the cpuset, block IO weight and ulimits of config.
+func validateResources(config *Config) error {
+	if config.Cpuset != "" {
+		if err := validateCpuset(config.Cpuset); err != nil {
+			return err
+		}
+	}
+	if config.BlkioWeight != 0 && (config.BlkioWeight < 10 || config.BlkioWeight > 1000) {
+		return fmt.Errorf("Invalid block IO weight: %d (expected 10 to 1000)", config.BlkioWeight)
+	}
+	for _, ulimit := range config.Ulimits {
+		if err := ulimit.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
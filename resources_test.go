@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseUlimit(t *testing.T) {
+	valid := map[string]Ulimit{
+		"nofile=1024:2048": {"nofile", 1024, 2048},
+		"nproc=512":        {"nproc", 512, 512},
+		"core=0:0":         {"core", 0, 0},
+	}
+	for value, expected := range valid {
+		ulimit, err := parseUlimit(value)
+		if err != nil {
+			t.Fatalf("%s: %s", value, err)
+		}
+		if *ulimit != expected {
+			t.Fatalf("%s: expected %v, got %v", value, expected, *ulimit)
+		}
+		if ulimit.String() != expected.String() {
+			t.Fatalf("%s: expected %s, got %s", value, expected.String(), ulimit)
+		}
+	}
+	for _, value := range []string{"nofile", "nofile=", "nofile=a:b", "nofile=2048:1024", "nofile=-1", "files=1024"} {
+		if _, err := parseUlimit(value); err == nil {
+			t.Fatalf("%s should not be a valid ulimit", value)
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	for _, cpuset := range []string{"0", "0-3", "0,2", "0-2,4,6-7"} {
+		if err := validateResources(&Config{Cpuset: cpuset}); err != nil {
+			t.Fatalf("%s: %s", cpuset, err)
+		}
+	}
+	for _, cpuset := range []string{"a", "0-", "3-1", "0,,1", "-1"} {
+		if err := validateResources(&Config{Cpuset: cpuset}); err == nil {
+			t.Fatalf("%s should not be a valid cpuset", cpuset)
+		}
+	}
+	if err := validateResources(&Config{BlkioWeight: 500}); err != nil {
+		t.Fatal(err)
+	}
+	for _, weight := range []int64{5, 1001, -1} {
+		if err := validateResources(&Config{BlkioWeight: weight}); err == nil {
+			t.Fatalf("%d should not be a valid block IO weight", weight)
+		}
+	}
+	if err := validateResources(&Config{Ulimits: []Ulimit{{"nofile", 2048, 1024}}}); err == nil {
+		t.Fatal("A soft limit above the hard limit should fail")
+	}
+	config := &Config{}
+	if err := json.Unmarshal([]byte(`{"Ulimits":[null]}`), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateResources(config); err == nil {
+		t.Fatal("A null ulimit should fail")
+	}
+}
+
+func TestStartWithUlimits(t *testing.T) {
+	if !hasCapability(t, 24) {
+		t.Skip("Raising a hard limit requires CAP_SYS_RESOURCE")
+	}
+	var before syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &before); err != nil {
+		t.Fatal(err)
+	}
+	if before.Max == ^uint64(0) {
+		lowered := syscall.Rlimit{Cur: 0, Max: 1024}
+		if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &lowered); err != nil {
+			t.Fatal(err)
+		}
+		defer syscall.Setrlimit(syscall.RLIMIT_CORE, &before)
+		before = lowered
+	}
+	hard := int64(before.Max) + 1024
+
+	// The blocks of ulimit -c are 512 bytes with sh
+	cmd := exec.Command("sh", "-c", "ulimit -H -c")
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	if err := startWithUlimits(cmd, []Ulimit{{"core", 0, hard}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := strconv.ParseInt(strings.TrimSpace(output.String()), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks*512 < hard && blocks*1024 < hard {
+		t.Fatalf("Expected a hard limit of at least %d bytes, got %d blocks", hard, blocks)
+	}
+
+	var after syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatalf("The limits of the daemon should be restored to %v, got %v", before, after)
+	}
+}
+
+// hasCapability returns true if the capability number capability is in the
+// effective set of the test process.
+func hasCapability(t *testing.T, capability uint) bool {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			caps, err := strconv.ParseUint(strings.TrimSpace(line[len("CapEff:"):]), 16, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return caps&(1<<capability) != 0
+		}
+	}
+	return false
+}
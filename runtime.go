@@ -10,16 +10,25 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 var defaultDns = []string{"8.8.8.8", "8.8.4.4"}
 
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Names which could be taken for the ids shown by docker
+var idLikeContainerName = regexp.MustCompile(`^[a-f0-9]{12,64}$`)
+
 type Capabilities struct {
 	MemoryLimit            bool
 	SwapLimit              bool
+	Cpuset                 bool
+	BlkioWeight            bool
 	IPv4ForwardingDisabled bool
 }
 
@@ -29,12 +38,17 @@ type Runtime struct {
 	containers     *list.List
 	networkManager *NetworkManager
 	graph          *Graph
+	driver         GraphDriver
 	repositories   *TagStore
 	idIndex        *utils.TruncIndex
 	capabilities   *Capabilities
 	kernelVersion  *utils.KernelVersionInfo
 	autoRestart    bool
 	volumes        *Graph
+	links          *LinkGraph
+	execs          *execStore
+	namesLock      sync.Mutex
+	reservedNames  map[string]string
 	srv            *Server
 	Dns            []string
 }
@@ -64,9 +78,26 @@ func (runtime *Runtime) getContainerElement(id string) *list.Element {
 	return nil
 }
 
+func (runtime *Runtime) getByName(name string) *Container {
+	for e := runtime.containers.Front(); e != nil; e = e.Next() {
+		container := e.Value.(*Container)
+		if container.Name == name {
+			return container
+		}
+	}
+	return nil
+}
+
 // Get looks for a container by the specified ID or name, and returns it.
+// Full IDs take precedence over names, which take precedence over ID prefixes.
 // If the container is not found, or if an error occurs, nil is returned.
 func (runtime *Runtime) Get(name string) *Container {
+	if e := runtime.getContainerElement(name); e != nil {
+		return e.Value.(*Container)
+	}
+	if container := runtime.getByName(name); container != nil {
+		return container
+	}
 	id, err := runtime.idIndex.Get(name)
 	if err != nil {
 		return nil
@@ -116,6 +147,18 @@ func (runtime *Runtime) Register(container *Container) error {
 	if err := validateID(container.ID); err != nil {
 		return err
 	}
+	// Containers created before names were introduced get one now
+	if container.Name == "" {
+		runtime.namesLock.Lock()
+		container.Name = runtime.generateName(container.ID)
+		runtime.namesLock.Unlock()
+		if err := container.ToDisk(); err != nil {
+			return err
+		}
+	}
+	if c := runtime.getByName(container.Name); c != nil {
+		return fmt.Errorf("Conflict, The name %s is already assigned to %s", container.Name, utils.TruncateID(c.ID))
+	}
 
 	// init the wait lock
 	container.waitLock = make(chan struct{})
@@ -179,12 +222,31 @@ func (runtime *Runtime) Register(container *Container) error {
 	return nil
 }
 
-func (runtime *Runtime) LogToDisk(src *utils.WriteBroadcaster, dst, stream string) error {
-	log, err := os.OpenFile(dst, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+// StartLogging sends the stdout and stderr of a container to the log driver
+// requested by logConfig, completed with the defaults of the daemon.
+func (runtime *Runtime) StartLogging(container *Container, logConfig LogConfig) error {
+	if logConfig.Type == "" {
+		logConfig.Type = DefaultLogConfig.Type
+	}
+	if logConfig.MaxSize == 0 {
+		logConfig.MaxSize = DefaultLogConfig.MaxSize
+	}
+	if logConfig.MaxFiles == 0 {
+		logConfig.MaxFiles = DefaultLogConfig.MaxFiles
+	}
+	if logConfig.SyslogAddress == "" {
+		logConfig.SyslogAddress = DefaultLogConfig.SyslogAddress
+	}
+	driver, err := NewLogDriver(container, logConfig)
 	if err != nil {
 		return err
 	}
-	src.AddWriter(log, stream)
+	if driver == nil {
+		return nil
+	}
+	// Both streams share the same driver, which serializes their lines
+	container.stdout.AddLogWriter(driver, "stdout")
+	container.stderr.AddLogWriter(driver, "stderr")
 	return nil
 }
 
@@ -212,9 +274,40 @@ func (runtime *Runtime) Destroy(container *Container) error {
 	// Deregister the container before removing its directory, to avoid race conditions
 	runtime.idIndex.Delete(container.ID)
 	runtime.containers.Remove(element)
+	runtime.execs.DeleteContainer(container.ID)
+	if err := runtime.driver.Remove(container.ID); err != nil {
+		return fmt.Errorf("Driver %s failed to remove root filesystem %s: %s", runtime.driver, container.ID, err)
+	}
+	if err := runtime.driver.Remove(container.initLayerID()); err != nil {
+		return fmt.Errorf("Driver %s failed to remove init filesystem %s: %s", runtime.driver, container.initLayerID(), err)
+	}
 	if err := os.RemoveAll(container.root); err != nil {
 		return fmt.Errorf("Unable to remove filesystem for %v: %v", container.ID, err)
 	}
+	if err := runtime.links.RemoveParent(container.ID); err != nil {
+		return fmt.Errorf("Unable to remove links of %v: %v", container.ID, err)
+	}
+	return nil
+}
+
+// Mount asks the storage driver for the root filesystem of the container.
+func (runtime *Runtime) Mount(container *Container) error {
+	dir, err := runtime.driver.Get(container.ID)
+	if err != nil {
+		return fmt.Errorf("Error getting container %s from driver %s: %s", container.ID, runtime.driver, err)
+	}
+	if container.rootfs == "" {
+		container.rootfs = dir
+	} else if container.rootfs != dir {
+		return fmt.Errorf("Error: driver %s is returning inconsistent paths for container %s ('%s' then '%s')",
+			runtime.driver, container.ID, container.rootfs, dir)
+	}
+	return nil
+}
+
+// Unmount releases the root filesystem of the container.
+func (runtime *Runtime) Unmount(container *Container) error {
+	runtime.driver.Put(container.ID)
 	return nil
 }
 
@@ -266,6 +359,30 @@ func (runtime *Runtime) UpdateCapabilities(quiet bool) {
 		}
 	}
 
+	if cgroupCpusetMountpoint, err := utils.FindCgroupMountpoint("cpuset"); err != nil {
+		if !quiet {
+			log.Printf("WARNING: %s\n", err)
+		}
+	} else {
+		_, err = ioutil.ReadFile(path.Join(cgroupCpusetMountpoint, "cpuset.cpus"))
+		runtime.capabilities.Cpuset = err == nil
+		if !runtime.capabilities.Cpuset && !quiet {
+			log.Printf("WARNING: Your kernel does not support cgroup cpuset.")
+		}
+	}
+
+	if cgroupBlkioMountpoint, err := utils.FindCgroupMountpoint("blkio"); err != nil {
+		if !quiet {
+			log.Printf("WARNING: %s\n", err)
+		}
+	} else {
+		_, err = ioutil.ReadFile(path.Join(cgroupBlkioMountpoint, "blkio.weight"))
+		runtime.capabilities.BlkioWeight = err == nil
+		if !runtime.capabilities.BlkioWeight && !quiet {
+			log.Printf("WARNING: Your kernel does not support cgroup block IO weight.")
+		}
+	}
+
 	content, err3 := ioutil.ReadFile("/proc/sys/net/ipv4/ip_forward")
 	runtime.capabilities.IPv4ForwardingDisabled = err3 != nil || len(content) == 0 || content[0] != '1'
 	if runtime.capabilities.IPv4ForwardingDisabled && !quiet {
@@ -273,8 +390,84 @@ func (runtime *Runtime) UpdateCapabilities(quiet bool) {
 	}
 }
 
-// Create creates a new container from the given configuration.
-func (runtime *Runtime) Create(config *Config) (*Container, error) {
+// generateName returns a random name which is not used by any container yet,
+// or the short id of the container id once too many random names were taken.
+// namesLock must be held.
+func (runtime *Runtime) generateName(id string) string {
+	for i := 0; i < 10; i++ {
+		name := utils.GetRandomName(i)
+		if runtime.checkName(name, id) == nil {
+			return name
+		}
+	}
+	return utils.TruncateID(id)
+}
+
+func validateContainerName(name string) error {
+	if !validContainerName.MatchString(name) {
+		return fmt.Errorf("Invalid container name (%s), only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if idLikeContainerName.MatchString(name) {
+		return fmt.Errorf("Invalid container name (%s), names can't look like container ids", name)
+	}
+	return nil
+}
+
+// checkName returns an error if name is used or reserved by another container
+// than the container id. namesLock must be held.
+func (runtime *Runtime) checkName(name, id string) error {
+	if c := runtime.getByName(name); c != nil && c.ID != id {
+		return fmt.Errorf("Conflict, The name %s is already assigned to %s", name, utils.TruncateID(c.ID))
+	}
+	if reserver, exists := runtime.reservedNames[name]; exists && reserver != id {
+		return fmt.Errorf("Conflict, The name %s is already assigned to %s", name, utils.TruncateID(reserver))
+	}
+	return nil
+}
+
+// reserveName keeps name for the container id until releaseName, so that
+// concurrent creations and renames can't give it to another container. An
+// empty name is replaced by a random one.
+func (runtime *Runtime) reserveName(name, id string) (string, error) {
+	runtime.namesLock.Lock()
+	defer runtime.namesLock.Unlock()
+	if name == "" {
+		name = runtime.generateName(id)
+	} else if err := validateContainerName(name); err != nil {
+		return "", err
+	} else if err := runtime.checkName(name, id); err != nil {
+		return "", err
+	}
+	if runtime.reservedNames == nil {
+		runtime.reservedNames = make(map[string]string)
+	}
+	runtime.reservedNames[name] = id
+	return name, nil
+}
+
+func (runtime *Runtime) releaseName(name string) {
+	runtime.namesLock.Lock()
+	defer runtime.namesLock.Unlock()
+	delete(runtime.reservedNames, name)
+}
+
+// Rename changes the name of a container, which must not be used by another container.
+func (runtime *Runtime) Rename(container *Container, name string) error {
+	if err := validateContainerName(name); err != nil {
+		return err
+	}
+	runtime.namesLock.Lock()
+	defer runtime.namesLock.Unlock()
+	if err := runtime.checkName(name, container.ID); err != nil {
+		return err
+	}
+	container.Name = name
+	return container.ToDisk()
+}
+
+// Create creates a new container from the given configuration and with the given name.
+// If name is empty, a random name is generated.
+func (runtime *Runtime) Create(config *Config, name string) (*Container, error) {
 	// Lookup image
 	img, err := runtime.repositories.LookupImage(config.Image)
 	if err != nil {
@@ -293,6 +486,12 @@ func (runtime *Runtime) Create(config *Config) (*Container, error) {
 
 	// Generate id
 	id := GenerateID()
+	// Keep the name until the container is registered
+	name, err = runtime.reserveName(name, id)
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.releaseName(name)
 	// Generate default hostname
 	// FIXME: the lxc template no longer needs to set a default hostname
 	if config.Hostname == "" {
@@ -313,6 +512,7 @@ func (runtime *Runtime) Create(config *Config) (*Container, error) {
 	container := &Container{
 		// FIXME: we should generate the ID here instead of receiving it as an argument
 		ID:              id,
+		Name:            name,
 		Created:         time.Now(),
 		Path:            entrypoint,
 		Args:            args, //FIXME: de-duplicate from config
@@ -329,6 +529,25 @@ func (runtime *Runtime) Create(config *Config) (*Container, error) {
 		return nil, err
 	}
 
+	// Step 2: create the filesystem of the container: an init layer holding
+	// the dockerinit mountpoints, and the rw layer on top of it.
+	initID := container.initLayerID()
+	if err := runtime.driver.Create(initID, img.ID); err != nil {
+		return nil, err
+	}
+	initPath, err := runtime.driver.Get(initID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupInitLayer(initPath); err != nil {
+		runtime.driver.Put(initID)
+		return nil, err
+	}
+	runtime.driver.Put(initID)
+	if err := runtime.driver.Create(container.ID, initID); err != nil {
+		return nil, err
+	}
+
 	resolvConf, err := utils.GetResolvConf()
 	if err != nil {
 		return nil, err
@@ -362,38 +581,23 @@ func (runtime *Runtime) Create(config *Config) (*Container, error) {
 		container.ResolvConfPath = "/etc/resolv.conf"
 	}
 
-	// Step 2: save the container json
+	// Step 3: save the container json
 	if err := container.ToDisk(); err != nil {
 		return nil, err
 	}
 
-	// Step 3: if hostname, build hostname and hosts files
+	// Step 4: if hostname, build hostname and hosts files
 	container.HostnamePath = path.Join(container.root, "hostname")
 	ioutil.WriteFile(container.HostnamePath, []byte(container.Config.Hostname+"\n"), 0644)
 
-	hostsContent := []byte(`
-127.0.0.1	localhost
-::1		localhost ip6-localhost ip6-loopback
-fe00::0		ip6-localnet
-ff00::0		ip6-mcastprefix
-ff02::1		ip6-allnodes
-ff02::2		ip6-allrouters
-`)
-
 	container.HostsPath = path.Join(container.root, "hosts")
+	container.writeHostsFile(nil)
 
-	if container.Config.Domainname != "" {
-		hostsContent = append([]byte(fmt.Sprintf("::1\t\t%s.%s %s\n", container.Config.Hostname, container.Config.Domainname, container.Config.Hostname)), hostsContent...)
-		hostsContent = append([]byte(fmt.Sprintf("127.0.0.1\t%s.%s %s\n", container.Config.Hostname, container.Config.Domainname, container.Config.Hostname)), hostsContent...)
-	} else {
-		hostsContent = append([]byte(fmt.Sprintf("::1\t\t%s\n", container.Config.Hostname)), hostsContent...)
-		hostsContent = append([]byte(fmt.Sprintf("127.0.0.1\t%s\n", container.Config.Hostname)), hostsContent...)
-	}
-
-	ioutil.WriteFile(container.HostsPath, hostsContent, 0644)
-
-	// Step 4: register the container
+	// Step 5: register the container
 	if err := runtime.Register(container); err != nil {
+		runtime.driver.Remove(container.ID)
+		runtime.driver.Remove(initID)
+		os.RemoveAll(container.root)
 		return nil, err
 	}
 	return container, nil
@@ -453,11 +657,28 @@ func NewRuntimeFromDirectory(root string, autoRestart bool) (*Runtime, error) {
 		return nil, err
 	}
 
-	g, err := NewGraph(path.Join(root, "graph"))
+	driver, err := NewGraphDriver(GraphDriverName, root)
+	if err != nil {
+		return nil, err
+	}
+	utils.Debugf("Using graph driver %s", driver)
+
+	g, err := NewGraph(path.Join(root, "graph"), driver)
 	if err != nil {
 		return nil, err
 	}
-	volumes, err := NewGraph(path.Join(root, "volumes"))
+	if ad, ok := driver.(*AufsDriver); ok {
+		if err := ad.migrate(g, runtimeRepo); err != nil {
+			return nil, err
+		}
+	}
+
+	// Volumes are plain directories, whatever the driver used for images
+	volumesDriver, err := NewGraphDriver("vfs", root)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := NewGraph(path.Join(root, "volumes"), volumesDriver)
 	if err != nil {
 		return nil, err
 	}
@@ -472,17 +693,25 @@ func NewRuntimeFromDirectory(root string, autoRestart bool) (*Runtime, error) {
 	if err != nil {
 		return nil, err
 	}
+	links, err := NewLinkGraph(path.Join(root, "linkgraph.json"))
+	if err != nil {
+		return nil, err
+	}
+
 	runtime := &Runtime{
 		root:           root,
 		repository:     runtimeRepo,
 		containers:     list.New(),
 		networkManager: netManager,
 		graph:          g,
+		driver:         driver,
 		repositories:   repositories,
 		idIndex:        utils.NewTruncIndex(),
 		capabilities:   &Capabilities{},
 		autoRestart:    autoRestart,
 		volumes:        volumes,
+		links:          links,
+		execs:          newExecStore(),
 	}
 
 	if err := runtime.restore(); err != nil {
@@ -491,6 +720,11 @@ func NewRuntimeFromDirectory(root string, autoRestart bool) (*Runtime, error) {
 	return runtime, nil
 }
 
+// Close releases the resources held by the runtime, such as mounted filesystems.
+func (runtime *Runtime) Close() error {
+	return runtime.driver.Cleanup()
+}
+
 // History is a convenience type for storing a list of containers,
 // ordered by creation date.
 type History []*Container
@@ -2,6 +2,7 @@ package docker
 
 import (
 	"bytes"
+	"container/list"
 	"fmt"
 	"github.com/dotcloud/docker/utils"
 	"io"
@@ -148,6 +149,7 @@ func TestRuntimeCreate(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"ls", "-al"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -189,6 +191,7 @@ func TestRuntimeCreate(t *testing.T) {
 		&Config{
 			Image: GetTestImage(runtime).ID,
 		},
+		"",
 	)
 	if err == nil {
 		t.Fatal("Builder.Create should throw an error when Cmd is missing")
@@ -199,6 +202,7 @@ func TestRuntimeCreate(t *testing.T) {
 			Image: GetTestImage(runtime).ID,
 			Cmd:   []string{},
 		},
+		"",
 	)
 	if err == nil {
 		t.Fatal("Builder.Create should throw an error when Cmd is empty")
@@ -212,6 +216,7 @@ func TestDestroy(t *testing.T) {
 		Image: GetTestImage(runtime).ID,
 		Cmd:   []string{"ls", "-al"},
 	},
+		"",
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -256,6 +261,15 @@ func TestGet(t *testing.T) {
 	container1, _, _ := mkContainer(runtime, []string{"_", "ls", "-al"}, t)
 	defer runtime.Destroy(container1)
 
+	for _, name := range []string{container1.ID, utils.TruncateID(container1.ID)} {
+		if _, err := runtime.Create(&Config{
+			Image: GetTestImage(runtime).ID,
+			Cmd:   []string{"ls", "-al"},
+		}, name); err == nil {
+			t.Fatalf("Creating a container named like the id %s should fail", name)
+		}
+	}
+
 	container2, _, _ := mkContainer(runtime, []string{"_", "ls", "-al"}, t)
 	defer runtime.Destroy(container2)
 
@@ -276,6 +290,77 @@ func TestGet(t *testing.T) {
 
 }
 
+func TestContainerNames(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+
+	container1, err := runtime.Create(&Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"ls", "-al"},
+	}, "some_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(container1)
+
+	if container1.Name != "some_name" {
+		t.Fatalf("Expected name some_name, got %s", container1.Name)
+	}
+	if runtime.Get("some_name") != container1 {
+		t.Errorf("Get(some_name) returned %v while expecting %v", runtime.Get("some_name"), container1)
+	}
+
+	if _, err := runtime.Create(&Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"ls", "-al"},
+	}, "some_name"); err == nil {
+		t.Fatal("Creating a container with an existing name should fail")
+	}
+	if _, err := runtime.Create(&Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"ls", "-al"},
+	}, "/bad name"); err == nil {
+		t.Fatal("Creating a container with an invalid name should fail")
+	}
+
+	container2, _, _ := mkContainer(runtime, []string{"_", "ls", "-al"}, t)
+	defer runtime.Destroy(container2)
+	if container2.Name == "" {
+		t.Fatal("A name should have been generated")
+	}
+	if err := runtime.Rename(container2, "some_name"); err == nil {
+		t.Fatal("Renaming a container to an existing name should fail")
+	}
+	if err := runtime.Rename(container2, "other_name"); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.Get("other_name") != container2 {
+		t.Errorf("Get(other_name) returned %v while expecting %v", runtime.Get("other_name"), container2)
+	}
+}
+
+func TestGetPrecedence(t *testing.T) {
+	runtime := &Runtime{containers: list.New(), idIndex: utils.NewTruncIndex()}
+	first := &Container{ID: GenerateID()}
+	// Containers loaded from disk may have been named before names like ids
+	// were refused
+	second := &Container{ID: GenerateID(), Name: first.ID}
+	third := &Container{ID: GenerateID(), Name: "third"}
+	for _, container := range []*Container{first, second, third} {
+		runtime.containers.PushBack(container)
+		runtime.idIndex.Add(container.ID)
+	}
+	if runtime.Get(first.ID) != first {
+		t.Errorf("Get(%s) should return the container with this id, not the one with this name", first.ID)
+	}
+	if runtime.Get("third") != third {
+		t.Errorf("Get(third) returned %v while expecting %v", runtime.Get("third"), third)
+	}
+	if runtime.Get(utils.TruncateID(second.ID)) != second {
+		t.Errorf("Get(%s) returned %v while expecting %v", utils.TruncateID(second.ID), runtime.Get(utils.TruncateID(second.ID)), second)
+	}
+}
+
 func startEchoServerContainer(t *testing.T, proto string) (*Runtime, *Container, string) {
 	var err error
 	runtime := mkRuntime(t)
@@ -298,7 +383,7 @@ func startEchoServerContainer(t *testing.T, proto string) (*Runtime, *Container,
 			Image:     GetTestImage(runtime).ID,
 			Cmd:       []string{"sh", "-c", cmd},
 			PortSpecs: []string{fmt.Sprintf("%s/%s", strPort, proto)},
-		})
+		}, "")
 		if container != nil {
 			break
 		}
@@ -83,6 +83,30 @@ func (srv *Server) ContainerKill(name string) error {
 	return nil
 }
 
+func (srv *Server) ContainerPause(name string) error {
+	if container := srv.runtime.Get(name); container != nil {
+		if err := container.Pause(); err != nil {
+			return fmt.Errorf("Error pausing container %s: %s", name, err)
+		}
+		srv.LogEvent("pause", container.ShortID(), srv.runtime.repositories.ImageName(container.Image))
+	} else {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	return nil
+}
+
+func (srv *Server) ContainerUnpause(name string) error {
+	if container := srv.runtime.Get(name); container != nil {
+		if err := container.Unpause(); err != nil {
+			return fmt.Errorf("Error unpausing container %s: %s", name, err)
+		}
+		srv.LogEvent("unpause", container.ShortID(), srv.runtime.repositories.ImageName(container.Image))
+	} else {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	return nil
+}
+
 func (srv *Server) ContainerExport(name string, out io.Writer) error {
 	if container := srv.runtime.Get(name); container != nil {
 
@@ -139,7 +163,7 @@ func (srv *Server) ImageInsert(name, url, path string, out io.Writer, sf *utils.
 		return "", err
 	}
 
-	c, err := srv.runtime.Create(config)
+	c, err := srv.runtime.Create(config, "")
 	if err != nil {
 		return "", err
 	}
@@ -272,11 +296,14 @@ func (srv *Server) DockerInfo() *APIInfo {
 		Images:             imgcount,
 		MemoryLimit:        srv.runtime.capabilities.MemoryLimit,
 		SwapLimit:          srv.runtime.capabilities.SwapLimit,
+		Cpuset:             srv.runtime.capabilities.Cpuset,
+		BlkioWeight:        srv.runtime.capabilities.BlkioWeight,
 		IPv4Forwarding:     !srv.runtime.capabilities.IPv4ForwardingDisabled,
 		Debug:              os.Getenv("DEBUG") != "",
 		NFd:                utils.GetTotalUsedFds(),
 		NGoroutines:        runtime.NumGoroutine(),
 		LXCVersion:         lxcVersion,
+		Driver:             srv.runtime.driver.String(),
 		NEventsListener:    len(srv.events),
 		KernelVersion:      kernelVersion,
 		IndexServerAddress: auth.IndexServerAddress(),
@@ -308,6 +335,14 @@ func (srv *Server) ImageHistory(name string) ([]APIHistory, error) {
 		out.CreatedBy = strings.Join(img.ContainerConfig.Cmd, " ")
 		out.Tags = lookupMap[img.ID]
 		outs = append(outs, out)
+		for _, squashed := range img.Squashed {
+			outs = append(outs, APIHistory{
+				ID:        utils.TruncateID(squashed.ID),
+				Created:   squashed.Created.Unix(),
+				CreatedBy: strings.Join(squashed.CreatedBy, " "),
+				Squashed:  true,
+			})
+		}
 		return nil
 	})
 	return outs, nil
@@ -381,7 +416,8 @@ func (srv *Server) Containers(all, size bool, n int, since, before string) []API
 		displayed++
 
 		c := APIContainers{
-			ID: container.ID,
+			ID:   container.ID,
+			Name: container.Name,
 		}
 		c.Image = srv.runtime.repositories.ImageName(container.Image)
 		c.Command = fmt.Sprintf("%s %s", container.Path, strings.Join(container.Args, " "))
@@ -408,6 +444,34 @@ func (srv *Server) ContainerCommit(name, repo, tag, author, comment string, conf
 	return img.ShortID(), err
 }
 
+// ImageSquash merges the layers of the image `name` above its parent image
+// `parent` into a new image, tagged as repo:tag if repo isn't empty. With an
+// empty parent, all the layers of the image are merged.
+func (srv *Server) ImageSquash(name, parent, repo, tag string) (string, error) {
+	img, err := srv.runtime.repositories.LookupImage(name)
+	if err != nil {
+		return "", err
+	}
+	parentID := ""
+	if parent != "" {
+		parentImg, err := srv.runtime.repositories.LookupImage(parent)
+		if err != nil {
+			return "", err
+		}
+		parentID = parentImg.ID
+	}
+	squashed, err := srv.runtime.graph.Squash(img, parentID)
+	if err != nil {
+		return "", err
+	}
+	if repo != "" {
+		if err := srv.runtime.repositories.Set(repo, tag, squashed.ID, true); err != nil {
+			return "", err
+		}
+	}
+	return squashed.ShortID(), nil
+}
+
 func (srv *Server) ContainerTag(name, repo, tag string, force bool) error {
 	if err := srv.runtime.repositories.Set(repo, tag, name, force); err != nil {
 		return err
@@ -807,7 +871,7 @@ func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID,
 		return "", err
 	}
 
-	layerData, err := srv.runtime.graph.TempLayerArchive(imgID, Uncompressed, sf, out)
+	layerData, err := srv.runtime.graph.TempLayerArchive(imgID, sf, out)
 	if err != nil {
 		return "", fmt.Errorf("Failed to generate layer archive: %s", err)
 	}
@@ -908,7 +972,198 @@ func (srv *Server) ImageImport(src, repo, tag string, in io.Reader, out io.Write
 	return nil
 }
 
-func (srv *Server) ContainerCreate(config *Config) (string, error) {
+// ImageExport writes to out a tar archive of the image or repository called
+// name, including every layer of its history with its metadata, and a
+// repositories file holding its tags. The archive can be loaded back with
+// ImageLoad, eg. on a host without access to a registry.
+func (srv *Server) ImageExport(name string, out io.Writer) error {
+	tempdir, err := srv.runtime.graph.Mktemp("")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tempdir, 0700); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempdir)
+
+	repoName, tag := utils.ParseRepositoryTag(name)
+	repositories := make(map[string]Repository)
+	rootImages := []string{}
+	if repo, err := srv.runtime.repositories.Get(repoName); err != nil {
+		return err
+	} else if repo != nil && tag == "" {
+		// Export the whole repository
+		repositories[repoName] = repo
+		for _, id := range repo {
+			rootImages = append(rootImages, id)
+		}
+	} else {
+		img, err := srv.runtime.repositories.LookupImage(name)
+		if err != nil {
+			return err
+		}
+		if repo != nil && repo[tag] == img.ID {
+			repositories[repoName] = Repository{tag: img.ID}
+		}
+		rootImages = append(rootImages, img.ID)
+	}
+
+	exported := make(map[string]bool)
+	for _, id := range rootImages {
+		img, err := srv.runtime.graph.Get(id)
+		if err != nil {
+			return err
+		}
+		if err := img.WalkHistory(func(img *Image) error {
+			if exported[img.ID] {
+				return nil
+			}
+			exported[img.ID] = true
+			return srv.exportImage(img, tempdir)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(repositories) > 0 {
+		data, err := json.Marshal(repositories)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(tempdir, "repositories"), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	archive, err := Tar(tempdir, Uncompressed)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, archive); err != nil {
+		return err
+	}
+	srv.LogEvent("save", utils.TruncateID(rootImages[0]), name)
+	return nil
+}
+
+// exportImage writes the json and the layer of img in dir/<id>.
+func (srv *Server) exportImage(img *Image, dir string) error {
+	utils.Debugf("Exporting image %s", img.ID)
+	imgDir := path.Join(dir, img.ID)
+	if err := os.Mkdir(imgDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(imgDir, "VERSION"), []byte("1.0"), 0600); err != nil {
+		return err
+	}
+	jsonData, err := ioutil.ReadFile(jsonPath(srv.runtime.graph.imageRoot(img.ID)))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(imgDir, "json"), jsonData, 0600); err != nil {
+		return err
+	}
+	layer, err := img.TarLayer()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(imgDir, "layer.tar"), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, layer)
+	return err
+}
+
+// ImageLoad registers the images of an archive produced by ImageExport,
+// and sets the tags it contains.
+func (srv *Server) ImageLoad(in io.Reader) error {
+	tempdir, err := srv.runtime.graph.Mktemp("")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tempdir, 0700); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempdir)
+
+	if err := Untar(in, tempdir, nil); err != nil {
+		return err
+	}
+	dirs, err := ioutil.ReadDir(tempdir)
+	if err != nil {
+		return err
+	}
+	loading := make(map[string]bool)
+	for _, d := range dirs {
+		if d.IsDir() {
+			if err := srv.loadImage(tempdir, d.Name(), loading); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadFile(path.Join(tempdir, "repositories"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	repositories := make(map[string]Repository)
+	if err := json.Unmarshal(data, &repositories); err != nil {
+		return err
+	}
+	for repoName, repo := range repositories {
+		for tag, id := range repo {
+			if err := srv.runtime.repositories.Set(repoName, tag, id, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadImage registers the image dir/<id>, after its parents. loading holds
+// the images already loaded or being loaded, to detect parent cycles.
+func (srv *Server) loadImage(dir, id string, loading map[string]bool) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if srv.runtime.graph.Exists(id) {
+		return nil
+	}
+	if loading[id] {
+		return fmt.Errorf("Invalid image %s: it is its own parent", id)
+	}
+	loading[id] = true
+	jsonData, err := ioutil.ReadFile(path.Join(dir, id, "json"))
+	if err != nil {
+		return err
+	}
+	img, err := NewImgJSON(jsonData)
+	if err != nil {
+		return err
+	}
+	if img.ID != id {
+		return fmt.Errorf("Invalid image %s: its json describes %s", id, img.ID)
+	}
+	if img.Parent != "" {
+		if err := srv.loadImage(dir, img.Parent, loading); err != nil {
+			return err
+		}
+	}
+	layer, err := os.Open(path.Join(dir, id, "layer.tar"))
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	utils.Debugf("Loading image %s", id)
+	return srv.runtime.graph.Register(jsonData, layer, img)
+}
+
+func (srv *Server) ContainerCreate(config *Config, name string) (string, error) {
 
 	if config.Memory != 0 && config.Memory < 524288 {
 		return "", fmt.Errorf("Memory limit must be given in bytes (minimum 524288 bytes)")
@@ -921,7 +1176,17 @@ func (srv *Server) ContainerCreate(config *Config) (string, error) {
 	if config.Memory > 0 && !srv.runtime.capabilities.SwapLimit {
 		config.MemorySwap = -1
 	}
-	container, err := srv.runtime.Create(config)
+
+	if err := validateResources(config); err != nil {
+		return "", err
+	}
+	if config.Cpuset != "" && !srv.runtime.capabilities.Cpuset {
+		config.Cpuset = ""
+	}
+	if config.BlkioWeight > 0 && !srv.runtime.capabilities.BlkioWeight {
+		config.BlkioWeight = 0
+	}
+	container, err := srv.runtime.Create(config, name)
 	if err != nil {
 		if srv.runtime.graph.IsNotExist(err) {
 
@@ -950,11 +1215,34 @@ func (srv *Server) ContainerRestart(name string, t int) error {
 	return nil
 }
 
+func (srv *Server) ContainerRename(name, newName string) error {
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	if err := srv.runtime.Rename(container, newName); err != nil {
+		return fmt.Errorf("Error renaming container %s: %s", name, err)
+	}
+	srv.LogEvent("rename", container.ShortID(), srv.runtime.repositories.ImageName(container.Image))
+	return nil
+}
+
 func (srv *Server) ContainerDestroy(name string, removeVolume bool) error {
 	if container := srv.runtime.Get(name); container != nil {
 		if container.State.Running {
 			return fmt.Errorf("Impossible to remove a running container, please stop it first")
 		}
+		if parents := srv.runtime.links.Parents(container.ID); len(parents) > 0 {
+			names := make([]string, len(parents))
+			for i, id := range parents {
+				if parent := srv.runtime.Get(id); parent != nil {
+					names[i] = parent.Name
+				} else {
+					names[i] = utils.TruncateID(id)
+				}
+			}
+			return fmt.Errorf("Impossible to remove container %s, it is linked to by: %s", name, strings.Join(names, ", "))
+		}
 		volumes := make(map[string]struct{})
 		// Store all the deleted containers volumes
 		for _, volumeId := range container.Volumes {
@@ -1141,6 +1429,9 @@ func (srv *Server) ImageGetCached(imgID string, config *Config) (*Image, error)
 
 func (srv *Server) ContainerStart(name string, hostConfig *HostConfig) error {
 	if container := srv.runtime.Get(name); container != nil {
+		container.State.Lock()
+		container.State.RestartCount = 0
+		container.State.Unlock()
 		if err := container.Start(hostConfig); err != nil {
 			return fmt.Errorf("Error starting container %s: %s", name, err)
 		}
@@ -1177,49 +1468,275 @@ func (srv *Server) ContainerResize(name string, h, w int) error {
 	return fmt.Errorf("No such container: %s", name)
 }
 
-func (srv *Server) ContainerAttach(name string, logs, stream, stdin, stdout, stderr bool, in io.ReadCloser, out io.Writer) error {
+// Interval between the samples of ContainerStats
+var statsInterval = time.Second
+
+// ContainerStats writes to out a JSON sample of the resource usage of the
+// container every statsInterval, until the container stops. Only one sample
+// is written unless stream is set.
+func (srv *Server) ContainerStats(name string, stream bool, out io.Writer) error {
 	container := srv.runtime.Get(name)
 	if container == nil {
 		return fmt.Errorf("No such container: %s", name)
 	}
-	//logs
-	if logs {
-		cLog, err := container.ReadLog("json")
-		if err != nil && os.IsNotExist(err) {
-			// Legacy logs
-			utils.Debugf("Old logs format")
-			if stdout {
-				cLog, err := container.ReadLog("stdout")
-				if err != nil {
-					utils.Debugf("Error reading logs (stdout): %s", err)
-				} else if _, err := io.Copy(out, cLog); err != nil {
-					utils.Debugf("Error streaming logs (stdout): %s", err)
-				}
+	// Errors are only reported before anything was written
+	prev, err := container.Stats()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for {
+		time.Sleep(statsInterval)
+		cur, err := container.Stats()
+		if err != nil {
+			if container.State.Running {
+				utils.Debugf("Error reading the stats of %s: %s", container.ShortID(), err)
+			}
+			return nil
+		}
+		cur.CPUPercent = cpuPercent(prev, cur)
+		if err := enc.Encode(cur); err != nil {
+			// The client went away
+			utils.Debugf("Error sending the stats of %s: %s", container.ShortID(), err)
+			return nil
+		}
+		if !stream {
+			return nil
+		}
+		prev = cur
+	}
+}
+
+// ContainerExecCreate prepares a process to run in the running container
+// name, and returns its id. The process is run by ExecStart.
+func (srv *Server) ContainerExecCreate(name string, config *ExecConfig) (string, error) {
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return "", fmt.Errorf("No such container: %s", name)
+	}
+	if len(config.Cmd) == 0 {
+		return "", fmt.Errorf("No command specified")
+	}
+	if !container.State.Running {
+		return "", fmt.Errorf("Container %s is not running", name)
+	}
+	if container.State.Ghost {
+		return "", fmt.Errorf("Impossible to exec in a ghost container")
+	}
+	if container.State.Paused {
+		return "", fmt.Errorf("Container %s is paused, unpause it first", name)
+	}
+	e := newExec(container, config)
+	srv.runtime.execs.Add(e)
+	return e.ID, nil
+}
+
+// ExecStart runs the process id, streaming its output to out, and returns
+// once it exited. Its exit code is then given by ExecInspect.
+func (srv *Server) ExecStart(id string, in io.ReadCloser, out io.Writer) error {
+	e := srv.runtime.execs.Get(id)
+	if e == nil {
+		return fmt.Errorf("No such exec: %s", id)
+	}
+	return e.Start(in, out, out)
+}
+
+func (srv *Server) ExecResize(id string, h, w int) error {
+	if e := srv.runtime.execs.Get(id); e != nil {
+		return e.Resize(h, w)
+	}
+	return fmt.Errorf("No such exec: %s", id)
+}
+
+func (srv *Server) ExecInspect(id string) (*APIExec, error) {
+	e := srv.runtime.execs.Get(id)
+	if e == nil {
+		return nil, fmt.Errorf("No such exec: %s", id)
+	}
+	e.Lock()
+	defer e.Unlock()
+	return &APIExec{
+		ID:        e.ID,
+		Container: e.container.ID,
+		Cmd:       e.Config.Cmd,
+		Tty:       e.Config.Tty,
+		OpenStdin: e.Config.OpenStdin,
+		Running:   e.Running,
+		ExitCode:  e.ExitCode,
+	}, nil
+}
+
+// ContainerLogs writes the logs of a container to out, reading across the
+// segments of rotated logs. Only the last tail lines are written, unless
+// tail is negative. When follow is set, new output is streamed until the
+// container stops or closed is signaled.
+func (srv *Server) ContainerLogs(name string, follow, timestamps bool, tail int, stdout, stderr bool, out io.Writer, closed <-chan bool) error {
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	if hostConfig, err := container.ReadHostConfig(); err == nil {
+		logType := hostConfig.LogConfig.Type
+		if logType == "" {
+			logType = DefaultLogConfig.Type
+		}
+		if logType != "json-file" {
+			return fmt.Errorf("Logs are only available with the json-file log driver, %s uses %s", name, logType)
+		}
+	}
+	segments := container.logSegments()
+	if len(segments) == 0 {
+		// Legacy logs can only be read entirely
+		utils.Debugf("Old logs format")
+		for _, stream := range []string{"stdout", "stderr"} {
+			if (stream == "stdout" && !stdout) || (stream == "stderr" && !stderr) {
+				continue
+			}
+			cLog, err := container.ReadLog(stream)
+			if err != nil {
+				utils.Debugf("Error reading logs (%s): %s", stream, err)
+			} else if _, err := io.Copy(out, cLog); err != nil {
+				utils.Debugf("Error streaming logs (%s): %s", stream, err)
+			}
+		}
+		return nil
+	}
+
+	// Find the segment and the offset of the first line to write
+	first, offset := 0, int64(0)
+	if tail >= 0 {
+		remaining := tail
+		for first = len(segments) - 1; first >= 0; first-- {
+			off, lines, err := tailLogFile(segments[first], remaining)
+			if err != nil {
+				return err
 			}
-			if stderr {
-				cLog, err := container.ReadLog("stderr")
+			offset = off
+			if lines >= remaining || first == 0 {
+				break
+			}
+			remaining -= lines
+		}
+	}
+
+	copier := &logCopier{out: out, stdout: stdout, stderr: stderr, timestamps: timestamps}
+	for i := first; i < len(segments)-1; i++ {
+		f, err := os.Open(segments[i])
+		if err != nil {
+			return err
+		}
+		if i == first {
+			f.Seek(offset, os.SEEK_SET)
+		}
+		err = copier.copy(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Read the current segment, following it across rotations if requested
+	current := segments[len(segments)-1]
+	f, err := os.Open(current)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	if first == len(segments)-1 {
+		f.Seek(offset, os.SEEK_SET)
+	}
+	reader := bufio.NewReader(f)
+	for {
+		running := container.State.Running
+		if err := copier.copy(reader); err != nil {
+			return err
+		}
+		if !follow || !running {
+			return nil
+		}
+		if st, err := f.Stat(); err == nil {
+			if newSt, err := os.Stat(current); err == nil && !os.SameFile(st, newSt) {
+				// The log was rotated: finish the old file, then switch to the new one
+				if err := copier.copy(reader); err != nil {
+					return err
+				}
+				newF, err := os.Open(current)
 				if err != nil {
-					utils.Debugf("Error reading logs (stderr): %s", err)
-				} else if _, err := io.Copy(out, cLog); err != nil {
-					utils.Debugf("Error streaming logs (stderr): %s", err)
+					return err
 				}
+				f.Close()
+				f = newF
+				reader = bufio.NewReader(f)
+				continue
 			}
+		}
+		select {
+		case <-closed:
+			return nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func tailLogFile(pth string, n int) (int64, int, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return utils.TailFile(f, n)
+}
+
+// logCopier writes the lines of json log files to out, filtered by stream.
+type logCopier struct {
+	out                        io.Writer
+	stdout, stderr, timestamps bool
+	// Incomplete line, kept until the rest of it is written
+	partial []byte
+}
+
+// copy writes the lines read from r until EOF.
+func (c *logCopier) copy(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			c.partial = append(c.partial, line...)
+			return nil
 		} else if err != nil {
-			utils.Debugf("Error reading logs (json): %s", err)
+			return err
+		}
+		if len(c.partial) > 0 {
+			line = append(c.partial, line...)
+			c.partial = nil
+		}
+		var l utils.JSONLog
+		if err := json.Unmarshal(line, &l); err != nil {
+			utils.Debugf("Error streaming logs: %s", err)
+			continue
+		}
+		if (l.Stream == "stdout" && !c.stdout) || (l.Stream == "stderr" && !c.stderr) {
+			continue
+		}
+		if c.timestamps {
+			_, err = fmt.Fprintf(c.out, "%s %s", l.Created.Format(time.RFC3339Nano), l.Log)
 		} else {
-			dec := json.NewDecoder(cLog)
-			for {
-				var l utils.JSONLog
-				if err := dec.Decode(&l); err == io.EOF {
-					break
-				} else if err != nil {
-					utils.Debugf("Error streaming logs: %s", err)
-					break
-				}
-				if (l.Stream == "stdout" && stdout) || (l.Stream == "stderr" && stderr) {
-					fmt.Fprintf(out, "%s", l.Log)
-				}
-			}
+			_, err = fmt.Fprintf(c.out, "%s", l.Log)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (srv *Server) ContainerAttach(name string, logs, stream, stdin, stdout, stderr bool, in io.ReadCloser, out io.Writer) error {
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	//logs
+	if logs {
+		if err := srv.ContainerLogs(name, false, false, -1, stdout, stderr, out, nil); err != nil {
+			utils.Debugf("Error streaming logs: %s", err)
 		}
 	}
 
@@ -1315,6 +1832,11 @@ func NewServer(flGraphPath string, autoRestart, enableCors bool, dns ListOpts) (
 	return srv, nil
 }
 
+// Close releases the resources held by the server before the daemon exits.
+func (srv *Server) Close() error {
+	return srv.runtime.Close()
+}
+
 func (srv *Server) HTTPRequestFactory(metaHeaders map[string][]string) *utils.HTTPRequestFactory {
 	if srv.reqFactory == nil {
 		ud := utils.NewHTTPUserAgentDecorator(srv.versionInfos()...)
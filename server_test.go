@@ -1,7 +1,11 @@
 package docker
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/tar"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -89,7 +93,7 @@ func TestCreateRm(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id, err := srv.ContainerCreate(config)
+	id, err := srv.ContainerCreate(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,7 +123,7 @@ func TestCommit(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id, err := srv.ContainerCreate(config)
+	id, err := srv.ContainerCreate(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -129,6 +133,91 @@ func TestCommit(t *testing.T) {
 	}
 }
 
+func TestImageSaveLoad(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+
+	srv := &Server{runtime: runtime}
+
+	config, _, _, err := ParseRun([]string{GetTestImage(runtime).ID, "touch", "/saved"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.ContainerCreate(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgID, err := srv.ContainerCommit(id, "testsave", "testtag", "", "", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerDestroy(id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := bytes.NewBuffer(nil)
+	if err := srv.ImageExport("testsave", archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ImageDelete("testsave:testtag", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.repositories.LookupImage("testsave:testtag"); err == nil {
+		t.Fatal("The image should have been deleted")
+	}
+
+	if err := srv.ImageLoad(archive); err != nil {
+		t.Fatal(err)
+	}
+	img, err := runtime.repositories.LookupImage("testsave:testtag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(img.ID, imgID) {
+		t.Fatalf("Expected testsave:testtag to be %s, got %s", imgID, img.ID)
+	}
+	if img.Parent != GetTestImage(runtime).ID {
+		t.Fatalf("The history of the image was not preserved")
+	}
+}
+
+func TestImageLoadInvalidParents(t *testing.T) {
+	graph := tempGraph(t)
+	defer os.RemoveAll(graph.Root)
+	srv := &Server{runtime: &Runtime{graph: graph}}
+
+	a, b := GenerateID(), GenerateID()
+	for _, parents := range []map[string]string{
+		{a: a},
+		{a: b, b: a},
+		{a: "../../etc"},
+	} {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for id, parent := range parents {
+			json := fmt.Sprintf(`{"id":"%s","parent":"%s"}`, id, parent)
+			if err := tw.WriteHeader(&tar.Header{Name: id + "/json", Mode: 0600, Size: int64(len(json))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(json)); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: id + "/layer.tar", Mode: 0600}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.ImageLoad(buf); err == nil {
+			t.Fatalf("Loading images with the parents %v should have failed", parents)
+		}
+		if graph.Exists(a) {
+			t.Fatalf("The image %s should not have been loaded", a)
+		}
+	}
+}
+
 func TestCreateStartRestartStopStartKillRm(t *testing.T) {
 	runtime := mkRuntime(t)
 	defer nuke(runtime)
@@ -140,7 +229,7 @@ func TestCreateStartRestartStopStartKillRm(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	id, err := srv.ContainerCreate(config)
+	id, err := srv.ContainerCreate(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -198,6 +287,7 @@ func TestRunWithTooLowMemoryLimit(t *testing.T) {
 			CpuShares: 1000,
 			Cmd:       []string{"/bin/cat"},
 		},
+		"",
 	)
 	if err == nil {
 		t.Errorf("Memory limit is smaller than the allowed limit. Container creation should've failed!")
@@ -258,6 +348,54 @@ func TestContainerTop(t *testing.T) {
 	}
 }
 
+func TestContainerExec(t *testing.T) {
+	runtime := mkRuntime(t)
+	srv := &Server{runtime: runtime}
+	defer nuke(runtime)
+
+	c, hostConfig, err := mkContainer(runtime, []string{"-e", "FOO=bar", "_", "/bin/sh", "-c", "sleep 10"}, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(c)
+
+	config := &ExecConfig{Cmd: []string{"/bin/sh", "-c", "echo $FOO; exit 3"}}
+	if _, err := srv.ContainerExecCreate(c.ID, config); err == nil {
+		t.Fatal("Creating an exec in a stopped container should fail")
+	}
+
+	if err := c.Start(hostConfig); err != nil {
+		t.Fatal(err)
+	}
+	c.WaitTimeout(500 * time.Millisecond)
+
+	id, err := srv.ContainerExecCreate(c.ID, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := srv.ExecStart(id, nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if output := out.String(); output != "bar\n" {
+		t.Fatalf("Expected the environment of the container, got %q", output)
+	}
+	e, err := srv.ExecInspect(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Running || e.ExitCode != 3 {
+		t.Fatalf("Expected the exec to exit with 3, got %#v", e)
+	}
+	if err := srv.ExecStart(id, nil, out); err == nil {
+		t.Fatal("Starting an exec twice should fail")
+	}
+
+	if err := c.Kill(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPools(t *testing.T) {
 	runtime := mkRuntime(t)
 	srv := &Server{
@@ -362,7 +500,7 @@ func TestRmi(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	containerID, err := srv.ContainerCreate(config)
+	containerID, err := srv.ContainerCreate(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -383,7 +521,7 @@ func TestRmi(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	containerID, err = srv.ContainerCreate(config)
+	containerID, err = srv.ContainerCreate(config, "")
 	if err != nil {
 		t.Fatal(err)
 	}
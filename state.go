@@ -10,10 +10,13 @@ import (
 type State struct {
 	sync.Mutex
 	Running   bool
+	Paused    bool
 	Pid       int
 	ExitCode  int
 	StartedAt time.Time
 	Ghost     bool
+	// Number of times the container was restarted by its restart policy
+	RestartCount int
 }
 
 // String returns a human-readable description of the state
@@ -22,6 +25,9 @@ func (s *State) String() string {
 		if s.Ghost {
 			return fmt.Sprintf("Ghost")
 		}
+		if s.Paused {
+			return fmt.Sprintf("Up %s (Paused)", utils.HumanDuration(time.Now().Sub(s.StartedAt)))
+		}
 		return fmt.Sprintf("Up %s", utils.HumanDuration(time.Now().Sub(s.StartedAt)))
 	}
 	return fmt.Sprintf("Exit %d", s.ExitCode)
@@ -29,6 +35,7 @@ func (s *State) String() string {
 
 func (s *State) setRunning(pid int) {
 	s.Running = true
+	s.Paused = false
 	s.Ghost = false
 	s.ExitCode = 0
 	s.Pid = pid
@@ -37,6 +44,7 @@ func (s *State) setRunning(pid int) {
 
 func (s *State) setStopped(exitCode int) {
 	s.Running = false
+	s.Paused = false
 	s.Pid = 0
 	s.ExitCode = exitCode
 }
@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats reads the resource usage of the container from its memory, cpuacct
+// and blkio cgroups, and the counters of its veth interface. The memory,
+// blkio and network stats are skipped when they are not available.
+// CPUPercent is left to the caller, since it needs a previous sample.
+func (container *Container) Stats() (*APIStats, error) {
+	if !container.State.Running {
+		return nil, fmt.Errorf("Container %s is not running", container.ID)
+	}
+	stats := &APIStats{Read: time.Now()}
+	var err error
+	if stats.CPUUsage, err = container.readCgroupUint("cpuacct", "cpuacct.usage"); err != nil {
+		return nil, err
+	}
+	if err := container.memoryStats(stats); err != nil {
+		utils.Debugf("Skipping the memory stats of %s: %s", container.ID, err)
+	}
+	if err := container.blkioStats(stats); err != nil {
+		utils.Debugf("Skipping the blkio stats of %s: %s", container.ID, err)
+	}
+	if !container.Config.NetworkDisabled {
+		// Containers started by older versions have a veth named otherwise
+		if err := readVethStats(path.Join("/sys/class/net", getVethName(container), "statistics"), stats); err != nil {
+			utils.Debugf("Skipping the network stats of %s: %s", container.ID, err)
+		}
+	}
+	return stats, nil
+}
+
+func (container *Container) memoryStats(stats *APIStats) error {
+	usage, err := container.readCgroupUint("memory", "memory.usage_in_bytes")
+	if err != nil {
+		return err
+	}
+	maxUsage, err := container.readCgroupUint("memory", "memory.max_usage_in_bytes")
+	if err != nil {
+		return err
+	}
+	limit, err := container.readCgroupUint("memory", "memory.limit_in_bytes")
+	if err != nil {
+		return err
+	}
+	// Without a limit, the cgroup reports a huge value: the host memory is
+	// the actual limit
+	if total, err := hostMemTotal(); err == nil && (limit == 0 || limit > total) {
+		limit = total
+	}
+	stats.MemoryUsage, stats.MemoryMaxUsage, stats.MemoryLimit = usage, maxUsage, limit
+	if limit > 0 {
+		stats.MemoryPercent = float64(usage) / float64(limit) * 100
+	}
+	return nil
+}
+
+func (container *Container) blkioStats(stats *APIStats) error {
+	// The throttling stats are available with all the IO schedulers
+	blkio, err := container.openCgroupFile("blkio", "blkio.throttle.io_service_bytes")
+	if err != nil {
+		return err
+	}
+	defer blkio.Close()
+	read, write, err := parseBlkioServiceBytes(blkio)
+	if err != nil {
+		return err
+	}
+	stats.BlkioRead, stats.BlkioWrite = read, write
+	return nil
+}
+
+func (container *Container) openCgroupFile(subsystem, file string) (*os.File, error) {
+	mountpoint, err := utils.FindCgroupMountpoint(subsystem)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path.Join(mountpoint, "lxc", container.ID, file))
+}
+
+func (container *Container) readCgroupUint(subsystem, file string) (uint64, error) {
+	f, err := container.openCgroupFile(subsystem, file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// parseBlkioServiceBytes sums the bytes read and written on all the devices
+// listed by blkio.throttle.io_service_bytes, eg.
+//
+//	8:0 Read 4096
+//	8:0 Write 8192
+//	8:0 Total 12288
+//	Total 12288
+func parseBlkioServiceBytes(r io.Reader) (read, write uint64, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || (fields[1] != "Read" && fields[1] != "Write") {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid blkio stats: %s", scanner.Text())
+		}
+		if fields[1] == "Read" {
+			read += value
+		} else {
+			write += value
+		}
+	}
+	return read, write, scanner.Err()
+}
+
+// readVethStats reads the counters of the host side of the veth interface
+// of a container from its statistics directory in sysfs. What the host side
+// receives is what the container sends, and conversely. stats is left
+// unchanged if any counter can't be read.
+func readVethStats(dir string, stats *APIStats) error {
+	var rxBytes, rxPackets, txBytes, txPackets uint64
+	for file, counter := range map[string]*uint64{
+		"rx_bytes":   &txBytes,
+		"rx_packets": &txPackets,
+		"tx_bytes":   &rxBytes,
+		"tx_packets": &rxPackets,
+	} {
+		content, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		if *counter, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64); err != nil {
+			return fmt.Errorf("Invalid network stats in %s: %s", path.Join(dir, file), err)
+		}
+	}
+	stats.NetworkRxBytes, stats.NetworkRxPackets = rxBytes, rxPackets
+	stats.NetworkTxBytes, stats.NetworkTxPackets = txBytes, txPackets
+	return nil
+}
+
+// hostMemTotal returns the memory of the host in bytes, read from /proc/meminfo.
+func hostMemTotal() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16318060 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// cpuPercent returns the CPU used by the container between the samples
+// prev and cur, where 100% is one CPU fully used.
+func cpuPercent(prev, cur *APIStats) float64 {
+	elapsed := cur.Read.Sub(prev.Read)
+	if elapsed <= 0 || cur.CPUUsage < prev.CPUUsage {
+		return 0
+	}
+	return float64(cur.CPUUsage-prev.CPUUsage) / float64(elapsed.Nanoseconds()) * 100
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBlkioServiceBytes(t *testing.T) {
+	content := `8:16 Read 4096
+8:16 Write 0
+8:16 Sync 4096
+8:16 Async 0
+8:16 Total 4096
+8:0 Read 1024
+8:0 Write 8192
+8:0 Total 9216
+Total 13312
+`
+	read, write, err := parseBlkioServiceBytes(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 5120 || write != 8192 {
+		t.Fatalf("Expected 5120 bytes read and 8192 written, got %d and %d", read, write)
+	}
+
+	if _, _, err := parseBlkioServiceBytes(strings.NewReader("8:0 Read foo\n")); err == nil {
+		t.Fatal("Invalid blkio stats should fail")
+	}
+}
+
+func TestReadVethStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-veth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for file, value := range map[string]string{"rx_bytes": "648\n", "rx_packets": "8\n", "tx_bytes": "1296\n", "tx_packets": "16\n"} {
+		if err := ioutil.WriteFile(path.Join(dir, file), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := &APIStats{}
+	if err := readVethStats(dir, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.NetworkRxBytes != 1296 || stats.NetworkRxPackets != 16 || stats.NetworkTxBytes != 648 || stats.NetworkTxPackets != 8 {
+		t.Fatalf("Unexpected network stats: %#v", stats)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, "tx_bytes"), []byte("foo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stats = &APIStats{}
+	if err := readVethStats(dir, stats); err == nil {
+		t.Fatal("Invalid network stats should fail")
+	}
+	if stats.NetworkRxBytes != 0 || stats.NetworkRxPackets != 0 || stats.NetworkTxBytes != 0 || stats.NetworkTxPackets != 0 {
+		t.Fatalf("The network stats should be left at zero, got %#v", stats)
+	}
+
+	// The veth of containers started by older versions has another name
+	if err := readVethStats(path.Join(dir, "missing"), &APIStats{}); err == nil {
+		t.Fatal("A missing veth should fail")
+	}
+}
+
+func TestCPUPercent(t *testing.T) {
+	now := time.Now()
+	prev := &APIStats{Read: now, CPUUsage: 1000000000}
+	cur := &APIStats{Read: now.Add(2 * time.Second), CPUUsage: 2000000000}
+	if percent := cpuPercent(prev, cur); percent != 50 {
+		t.Fatalf("Expected 50%%, got %f", percent)
+	}
+	// The counter went back, eg. the container was restarted
+	if percent := cpuPercent(cur, prev); percent != 0 {
+		t.Fatalf("Expected 0%%, got %f", percent)
+	}
+}
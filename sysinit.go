@@ -30,6 +30,20 @@ func setupWorkingDirectory(workdir string) {
 	syscall.Chdir(workdir)
 }
 
+// Set the resource limits, before dropping privileges. The hard limits were
+// raised by the daemon if needed, since the container can only lower them
+func setupUlimits(ulimits ListOpts) {
+	for _, value := range ulimits {
+		ulimit, err := parseUlimit(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ulimit.setrlimit(); err != nil {
+			log.Fatalf("Unable to set ulimit %s: %v", ulimit, err)
+		}
+	}
+}
+
 // Takes care of dropping privileges to the desired user
 func changeUser(u string) {
 	if u == "" {
@@ -96,11 +110,15 @@ func SysInit() {
 	var flEnv ListOpts
 	flag.Var(&flEnv, "e", "Set environment variables")
 
+	var flUlimits ListOpts
+	flag.Var(&flUlimits, "ulimit", "Set a resource limit")
+
 	flag.Parse()
 
 	cleanupEnv(flEnv)
 	setupNetworking(*gw)
 	setupWorkingDirectory(*workdir)
+	setupUlimits(flUlimits)
 	changeUser(*u)
 	executeProgram(flag.Arg(0), flag.Args())
 }
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Options of the tmpfs mounts, before the ones given with -tmpfs
+const defaultTmpfsOptions = "nosuid,nodev,noexec"
+
+// Options accepted by -tmpfs, with or without a value
+var (
+	tmpfsValueOptions = []string{"size", "mode", "uid", "gid", "nr_inodes", "nr_blocks"}
+	tmpfsFlagOptions  = []string{"exec", "noexec", "suid", "nosuid", "dev", "nodev", "ro", "rw"}
+)
+
+// Paths of the container on which docker mounts files or filesystems
+var dockerMountpoints = []string{
+	"/proc",
+	"/sys",
+	"/dev/pts",
+	"/dev/shm",
+	"/.dockerinit",
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/hostname",
+}
+
+// parseTmpfs parses a -tmpfs argument of the form /path[:options], eg.
+// /run:size=64m,mode=755.
+func parseTmpfs(value string) (string, string, error) {
+	parts := strings.SplitN(value, ":", 2)
+	options := ""
+	if len(parts) == 2 {
+		options = parts[1]
+	}
+	if err := validateTmpfsOptions(options); err != nil {
+		return "", "", err
+	}
+	return parts[0], options, nil
+}
+
+func validateTmpfsOptions(options string) error {
+	if options == "" {
+		return nil
+	}
+	// The options are written as is in the lxc config
+	if strings.IndexFunc(options, isSpaceOrControl) >= 0 {
+		return fmt.Errorf("Invalid tmpfs options: %q (the options can't contain spaces)", options)
+	}
+	for _, option := range strings.Split(options, ",") {
+		name := strings.SplitN(option, "=", 2)[0]
+		valid := false
+		if name != option {
+			valid = isTmpfsOption(tmpfsValueOptions, name) && len(option) > len(name)+1
+		} else {
+			valid = isTmpfsOption(tmpfsFlagOptions, name)
+		}
+		if !valid {
+			return fmt.Errorf("Invalid tmpfs option: %s", option)
+		}
+	}
+	return nil
+}
+
+func isTmpfsOption(options []string, name string) bool {
+	for _, option := range options {
+		if name == option {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTmpfs checks the tmpfs mounts, which are mounted last and so can't
+// hide the root filesystem, the volumes, the devices or the files mounted by
+// docker. Their paths must be clean, since they are written as is in the lxc
+// config.
+func validateTmpfs(tmpfs map[string]string, volumes map[string]struct{}, devices []DeviceMapping) error {
+	for dst, options := range tmpfs {
+		if !path.IsAbs(dst) || path.Clean(dst) != dst {
+			return fmt.Errorf("Invalid tmpfs destination: %s (the path must be absolute and clean)", dst)
+		}
+		if strings.IndexFunc(dst, isSpaceOrControl) >= 0 {
+			return fmt.Errorf("Invalid tmpfs destination: %q (the path can't contain spaces)", dst)
+		}
+		if dst == "/" {
+			return fmt.Errorf("Conflicting options: -tmpfs can't be mounted on /, use -read-only")
+		}
+		for _, mountpoint := range dockerMountpoints {
+			if isPathInDir(mountpoint, dst) {
+				return fmt.Errorf("Conflicting options: -tmpfs %s would hide %s, mounted by docker", dst, mountpoint)
+			}
+		}
+		for volume := range volumes {
+			if isPathInDir(path.Clean(volume), dst) {
+				return fmt.Errorf("Conflicting options: -tmpfs %s would hide the volume %s", dst, volume)
+			}
+		}
+		for _, device := range devices {
+			if isPathInDir(device.PathInContainer, dst) {
+				return fmt.Errorf("Conflicting options: -tmpfs %s would hide the device %s", dst, device.PathInContainer)
+			}
+		}
+		if err := validateTmpfsOptions(options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// isPathInDir returns true if the clean path pth is dir or is inside of it.
+func isPathInDir(pth, dir string) bool {
+	return pth == dir || strings.HasPrefix(pth, dir+"/")
+}
+
+// tmpfsOptions returns the mount options of a tmpfs mount: the default ones
+// are overridden by the ones given with -tmpfs.
+func tmpfsOptions(options string) string {
+	if options == "" {
+		return defaultTmpfsOptions
+	}
+	return defaultTmpfsOptions + "," + options
+}
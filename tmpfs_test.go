@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"testing"
+)
+
+func TestParseTmpfs(t *testing.T) {
+	valid := map[string][2]string{
+		"/run":                        {"/run", ""},
+		"/run:size=64m":               {"/run", "size=64m"},
+		"/tmp:size=1g,mode=1777,exec": {"/tmp", "size=1g,mode=1777,exec"},
+	}
+	for value, expected := range valid {
+		dst, options, err := parseTmpfs(value)
+		if err != nil {
+			t.Fatalf("%s: %s", value, err)
+		}
+		if dst != expected[0] || options != expected[1] {
+			t.Fatalf("%s: expected %v, got %s and %s", value, expected, dst, options)
+		}
+	}
+	for _, value := range []string{"/run:size", "/run:size=", "/run:bind", "/run:size=64m,,mode=755", "/run:size=64m 0 0\nlxc.cgroup.devices.allow = a"} {
+		if _, _, err := parseTmpfs(value); err == nil {
+			t.Fatalf("%s should not be a valid tmpfs", value)
+		}
+	}
+}
+
+func TestValidateTmpfs(t *testing.T) {
+	volumes := map[string]struct{}{"/data": {}, "/var/lib/db": {}}
+	devices := []DeviceMapping{{"/dev/fuse", "/opt/fuse/dev", "rwm"}}
+	if err := validateTmpfs(map[string]string{"/run": "size=64m", "/tmp": "", "/data/cache": "", "/dev/mqueue": ""}, volumes, devices); err != nil {
+		t.Fatal(err)
+	}
+	for _, dst := range []string{"run", "/", "/proc", "/etc/hosts", "/data", "/data/", "/etc", "/dev", "/var/lib", "/opt/fuse", "/run/../etc", "/my run", "/run\nlxc.cap.drop"} {
+		if err := validateTmpfs(map[string]string{dst: ""}, volumes, devices); err == nil {
+			t.Fatalf("A tmpfs on %s should fail", dst)
+		}
+	}
+}
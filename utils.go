@@ -18,6 +18,8 @@ func CompareConfig(a, b *Config) bool {
 		a.Memory != b.Memory ||
 		a.MemorySwap != b.MemorySwap ||
 		a.CpuShares != b.CpuShares ||
+		a.Cpuset != b.Cpuset ||
+		a.BlkioWeight != b.BlkioWeight ||
 		a.OpenStdin != b.OpenStdin ||
 		a.Tty != b.Tty ||
 		a.VolumesFrom != b.VolumesFrom {
@@ -28,7 +30,9 @@ func CompareConfig(a, b *Config) bool {
 		len(a.Env) != len(b.Env) ||
 		len(a.PortSpecs) != len(b.PortSpecs) ||
 		len(a.Entrypoint) != len(b.Entrypoint) ||
-		len(a.Volumes) != len(b.Volumes) {
+		len(a.Volumes) != len(b.Volumes) ||
+		len(a.OnBuild) != len(b.OnBuild) ||
+		len(a.Ulimits) != len(b.Ulimits) {
 		return false
 	}
 
@@ -62,6 +66,16 @@ func CompareConfig(a, b *Config) bool {
 			return false
 		}
 	}
+	for i := 0; i < len(a.OnBuild); i++ {
+		if a.OnBuild[i] != b.OnBuild[i] {
+			return false
+		}
+	}
+	for i := 0; i < len(a.Ulimits); i++ {
+		if a.Ulimits[i] != b.Ulimits[i] {
+			return false
+		}
+	}
 	return true
 }
 
@@ -78,6 +92,15 @@ func MergeConfig(userConf, imageConf *Config) {
 	if userConf.CpuShares == 0 {
 		userConf.CpuShares = imageConf.CpuShares
 	}
+	if userConf.Cpuset == "" {
+		userConf.Cpuset = imageConf.Cpuset
+	}
+	if userConf.BlkioWeight == 0 {
+		userConf.BlkioWeight = imageConf.BlkioWeight
+	}
+	if len(userConf.Ulimits) == 0 {
+		userConf.Ulimits = imageConf.Ulimits
+	}
 	if userConf.PortSpecs == nil || len(userConf.PortSpecs) == 0 {
 		userConf.PortSpecs = imageConf.PortSpecs
 	} else {
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var (
+	left = [...]string{
+		"angry", "berserk", "boring", "clever", "condescending", "cranky", "desperate",
+		"distracted", "drunk", "ecstatic", "elated", "focused", "furious", "goofy",
+		"grave", "happy", "hopeful", "hungry", "jolly", "loving", "mad", "naughty",
+		"nostalgic", "pensive", "prickly", "sad", "sharp", "sick", "silly", "sleepy",
+		"stoic", "suspicious", "tender", "thirsty", "trusting", "zen",
+	}
+
+	// Scientists and hackers, for the right side of generated names.
+	right = [...]string{
+		"albattani", "archimedes", "babbage", "bardeen", "bell", "bohr", "brattain",
+		"curie", "darwin", "davinci", "einstein", "euclid", "fermat", "fermi",
+		"feynman", "galileo", "goldstine", "hawking", "heisenberg", "hodgkin",
+		"hopper", "hypatia", "kepler", "lalande", "lovelace", "lumiere", "mayer",
+		"mccarthy", "newton", "nobel", "pare", "pasteur", "pike", "ptolemy",
+		"ritchie", "shockley", "tesla", "thompson", "torvalds", "turing", "wozniak",
+		"wright", "yonath",
+	}
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// GetRandomName generates a human-readable name such as "focused_turing".
+// If retry is non-zero, a random integer between 0 and 9 is appended to
+// reduce the risk of collisions.
+func GetRandomName(retry int) string {
+	name := fmt.Sprintf("%s_%s", left[rand.Intn(len(left))], right[rand.Intn(len(right))])
+	if retry > 0 {
+		name = fmt.Sprintf("%s%d", name, rand.Intn(10))
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only file which is rotated once it reaches
+// MaxSize bytes: path is renamed to path.1, path.1 to path.2, and so on,
+// keeping at most MaxFiles files including the current one.
+// A write is never split across two files, so writing whole lines keeps
+// every segment readable on its own.
+type RotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+// NewRotatingFile opens path for appending. A maxSize of 0 disables rotation.
+func NewRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = st.Size()
+	return nil
+}
+
+// RotatedPath returns the path of the i-th rotated segment of the file at path.
+// The segment 0 is the current file.
+func RotatedPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := rf.f.Close()
+	rf.f = nil
+	if err != nil {
+		return err
+	}
+	// The oldest segment is dropped; with a single file, the current one is
+	if err := os.Remove(RotatedPath(rf.path, rf.maxFiles-1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rf.maxFiles - 2; i >= 0; i-- {
+		if err := os.Rename(RotatedPath(rf.path, i), RotatedPath(rf.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.f == nil {
+		return 0, fmt.Errorf("%s is closed", rf.path)
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file. It can safely be called several times.
+func (rf *RotatingFile) Close() error {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"io"
+	"os"
+)
+
+const tailBlockSize = 4096
+
+// TailFile returns the offset at which the last n lines of f begin, and the
+// number of lines found after it, which is less than n for short files.
+// The file is read backwards by blocks, so that only the end of a large
+// file is actually read.
+func TailFile(f io.ReadSeeker, n int) (int64, int, error) {
+	size, err := f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n <= 0 {
+		return size, 0, nil
+	}
+	buf := make([]byte, tailBlockSize)
+	lines := 0
+	for end := size; end > 0; {
+		start := end - tailBlockSize
+		if start < 0 {
+			start = 0
+		}
+		block := buf[:end-start]
+		if _, err := f.Seek(start, os.SEEK_SET); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(f, block); err != nil {
+			return 0, 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			// The newline ending the file doesn't start a line
+			if block[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			lines++
+			if lines == n {
+				return start + int64(i) + 1, lines, nil
+			}
+		}
+		end = start
+	}
+	if size > 0 {
+		// The first line of the file has no newline before it
+		lines++
+	}
+	return 0, lines, nil
+}
@@ -152,6 +152,34 @@ func HumanSize(size int64) string {
 	return fmt.Sprintf("%.4g %s", sizef, units[i])
 }
 
+// RAMInBytes parses a human-readable size using binary units
+// (eg. "512", "64k", "10m", "1g") and returns it in bytes.
+func RAMInBytes(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	if size == "" {
+		return 0, fmt.Errorf("Invalid size: empty string")
+	}
+	var multiplier int64 = 1
+	switch size[len(size)-1] {
+	case 'b':
+		size = size[:len(size)-1]
+	case 'k':
+		multiplier = 1024
+		size = size[:len(size)-1]
+	case 'm':
+		multiplier = 1024 * 1024
+		size = size[:len(size)-1]
+	case 'g':
+		multiplier = 1024 * 1024 * 1024
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size: %s", size)
+	}
+	return n * multiplier, nil
+}
+
 func Trunc(s string, maxlen int) string {
 	if len(s) <= maxlen {
 		return s
@@ -249,8 +277,9 @@ func (r *bufReader) Close() error {
 
 type WriteBroadcaster struct {
 	sync.Mutex
-	buf     *bytes.Buffer
-	writers map[StreamWriter]bool
+	buf        *bytes.Buffer
+	writers    map[StreamWriter]bool
+	logWriters map[streamLogWriter]bool
 }
 
 type StreamWriter struct {
@@ -258,6 +287,18 @@ type StreamWriter struct {
 	stream string
 }
 
+// A LogWriter receives each line written to a WriteBroadcaster, along with
+// the name of its stream and the time it was written at.
+type LogWriter interface {
+	WriteLog(*JSONLog) error
+	Close() error
+}
+
+type streamLogWriter struct {
+	lw     LogWriter
+	stream string
+}
+
 func (w *WriteBroadcaster) AddWriter(writer io.WriteCloser, stream string) {
 	w.Lock()
 	sw := StreamWriter{wc: writer, stream: stream}
@@ -265,6 +306,14 @@ func (w *WriteBroadcaster) AddWriter(writer io.WriteCloser, stream string) {
 	w.Unlock()
 }
 
+// AddLogWriter sends every complete line written to the broadcaster to
+// writer, tagged as coming from stream.
+func (w *WriteBroadcaster) AddLogWriter(writer LogWriter, stream string) {
+	w.Lock()
+	w.logWriters[streamLogWriter{lw: writer, stream: stream}] = true
+	w.Unlock()
+}
+
 type JSONLog struct {
 	Log     string    `json:"log,omitempty"`
 	Stream  string    `json:"stream,omitempty"`
@@ -275,17 +324,24 @@ func (w *WriteBroadcaster) Write(p []byte) (n int, err error) {
 	w.Lock()
 	defer w.Unlock()
 	w.buf.Write(p)
+	// Split the complete lines once for all the writers which log them,
+	// keeping the last incomplete line for the next write
+	var lines []string
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			w.buf.Write([]byte(line))
+			break
+		}
+		lines = append(lines, line)
+	}
+	created := time.Now()
 	for sw := range w.writers {
 		lp := p
 		if sw.stream != "" {
 			lp = nil
-			for {
-				line, err := w.buf.ReadString('\n')
-				if err != nil {
-					w.buf.Write([]byte(line))
-					break
-				}
-				b, err := json.Marshal(&JSONLog{Log: line, Stream: sw.stream, Created: time.Now()})
+			for _, line := range lines {
+				b, err := json.Marshal(&JSONLog{Log: line, Stream: sw.stream, Created: created})
 				if err != nil {
 					// On error, evict the writer
 					delete(w.writers, sw)
@@ -300,6 +356,15 @@ func (w *WriteBroadcaster) Write(p []byte) (n int, err error) {
 			delete(w.writers, sw)
 		}
 	}
+	for slw := range w.logWriters {
+		for _, line := range lines {
+			if err := slw.lw.WriteLog(&JSONLog{Log: line, Stream: slw.stream, Created: created}); err != nil {
+				// On error, evict the writer
+				delete(w.logWriters, slw)
+				break
+			}
+		}
+	}
 	return len(p), nil
 }
 
@@ -309,12 +374,20 @@ func (w *WriteBroadcaster) CloseWriters() error {
 	for sw := range w.writers {
 		sw.wc.Close()
 	}
+	for slw := range w.logWriters {
+		slw.lw.Close()
+	}
 	w.writers = make(map[StreamWriter]bool)
+	w.logWriters = make(map[streamLogWriter]bool)
 	return nil
 }
 
 func NewWriteBroadcaster() *WriteBroadcaster {
-	return &WriteBroadcaster{writers: make(map[StreamWriter]bool), buf: bytes.NewBuffer(nil)}
+	return &WriteBroadcaster{
+		writers:    make(map[StreamWriter]bool),
+		logWriters: make(map[streamLogWriter]bool),
+		buf:        bytes.NewBuffer(nil),
+	}
 }
 
 func GetTotalUsedFds() int {
@@ -3,8 +3,11 @@ package utils
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -113,6 +116,43 @@ func TestWriteBroadcaster(t *testing.T) {
 	writer.CloseWriters()
 }
 
+type dummyLogWriter struct {
+	logs   []*JSONLog
+	closed bool
+}
+
+func (dw *dummyLogWriter) WriteLog(l *JSONLog) error {
+	dw.logs = append(dw.logs, l)
+	return nil
+}
+
+func (dw *dummyLogWriter) Close() error {
+	dw.closed = true
+	return nil
+}
+
+func TestWriteBroadcasterLogWriter(t *testing.T) {
+	writer := NewWriteBroadcaster()
+	logWriter := &dummyLogWriter{}
+	writer.AddLogWriter(logWriter, "stderr")
+
+	writer.Write([]byte("hello\nwor"))
+	writer.Write([]byte("ld\n"))
+	if len(logWriter.logs) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(logWriter.logs))
+	}
+	for i, expected := range []string{"hello\n", "world\n"} {
+		l := logWriter.logs[i]
+		if l.Log != expected || l.Stream != "stderr" || l.Created.IsZero() {
+			t.Fatalf("Unexpected log entry: %#v", l)
+		}
+	}
+	writer.CloseWriters()
+	if !logWriter.closed {
+		t.Fatal("The log writer should have been closed")
+	}
+}
+
 type devNullCloser int
 
 func (d devNullCloser) Close() error {
@@ -421,3 +461,100 @@ func TestDependencyGraph(t *testing.T) {
 		t.Fatalf("Expected [d], found %v instead", res[2])
 	}
 }
+
+func TestGetRandomName(t *testing.T) {
+	name := GetRandomName(0)
+	if !strings.Contains(name, "_") {
+		t.Fatalf("Expected a name of the form adjective_surname, got %s", name)
+	}
+	if retried := GetRandomName(1); retried[len(retried)-1] < '0' || retried[len(retried)-1] > '9' {
+		t.Fatalf("Expected a trailing digit when retrying, got %s", retried)
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	var content []string
+	for i := 0; i < 3000; i++ {
+		content = append(content, fmt.Sprintf("line %d", i))
+	}
+	f := strings.NewReader(strings.Join(content, "\n") + "\n")
+	for _, n := range []int{0, 1, 10, 1000, 3000, 5000} {
+		offset, lines, err := TailFile(f, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Seek(offset, os.SEEK_SET)
+		tail, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := n
+		if expected > len(content) {
+			expected = len(content)
+		}
+		if lines != expected {
+			t.Fatalf("Expected %d lines to be found, got %d", expected, lines)
+		}
+		if expected == 0 {
+			if len(tail) != 0 {
+				t.Fatalf("Expected no output for 0 lines, got %q", tail)
+			}
+			continue
+		}
+		tailLines := strings.Split(strings.TrimSuffix(string(tail), "\n"), "\n")
+		if len(tailLines) != expected {
+			t.Fatalf("Expected %d lines, got %d", expected, len(tailLines))
+		}
+		if tailLines[0] != content[len(content)-expected] {
+			t.Fatalf("Expected first line %q, got %q", content[len(content)-expected], tailLines[0])
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-utils-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	logPath := filepath.Join(tmp, "test.log")
+	rf, err := NewRotatingFile(logPath, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// line 1 was dropped with the oldest segment
+	for i, expected := range []string{"line 4\n", "line 3\n", "line 2\n"} {
+		content, err := ioutil.ReadFile(RotatedPath(logPath, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Segment %d: expected %q, got %q", i, expected, content)
+		}
+	}
+	if _, err := os.Stat(RotatedPath(logPath, 3)); !os.IsNotExist(err) {
+		t.Fatalf("Only 3 files should be kept")
+	}
+}
+
+func TestRAMInBytes(t *testing.T) {
+	for size, expected := range map[string]int64{"32": 32, "32b": 32, "32k": 32 * 1024, "32M": 32 * 1024 * 1024, "2g": 2 * 1024 * 1024 * 1024} {
+		if n, err := RAMInBytes(size); err != nil || n != expected {
+			t.Fatalf("%s: expected %d, got %d (%v)", size, expected, n, err)
+		}
+	}
+	for _, size := range []string{"", "k", "-1", "12x"} {
+		if _, err := RAMInBytes(size); err == nil {
+			t.Fatalf("%s should not be a valid size", size)
+		}
+	}
+}